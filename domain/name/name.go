@@ -30,30 +30,33 @@ func ParsePublicSuffixCSV(csv string, accepted map[string]bool, includeTLDs bool
 	return psl, nil
 }
 
-// ParseDNSCSV parse a CSV string into a cleaned slice of strings
-func ParseDNSCSV(csv string) []string {
-	psl := wordlist.FromCSV(csv)
-	psl = wordlist.RemoveDuplicates(psl)
-	sort.Strings(psl)
-	return psl
-}
-
 // CombinePhraseAndPublicSuffixes combine phrases (combined words) with public suffixes, with out without domain hacks
 // and return a slice of strings
 func CombinePhraseAndPublicSuffixes(word string, psl []string, hacks bool) []string {
 	var domains []string
+	eachPhraseDomain(word, psl, hacks, func(domain string) bool {
+		domains = append(domains, domain)
+		return true
+	})
+	return domains
+}
+
+// eachPhraseDomain calls yield with each domain CombinePhraseAndPublicSuffixes would return, until it returns false
+func eachPhraseDomain(word string, psl []string, hacks bool, yield func(string) bool) bool {
 	for _, ps := range psl {
-		domains = append(domains, word+"."+ps)
+		if !yield(word + "." + ps) {
+			return false
+		}
 		if hacks {
 			if strings.HasSuffix(word, ps) {
 				last := strings.LastIndex(word, ps)
-				if last > 0 {
-					domains = append(domains, word[:last]+"."+ps)
+				if last > 0 && !yield(word[:last]+"."+ps) {
+					return false
 				}
 			}
 		}
 	}
-	return domains
+	return true
 }
 
 // CombinePrefixAndSuffix combine two words in all possible combinations with out without hyphenation. A suffix never
@@ -87,45 +90,72 @@ func CombinePrefixAndSuffix(prefix, suffix string, itself, hyphenate, fuse bool,
 // Combine words and public suffixes to make the ordered domain list
 func Combine(prefixes, suffixes, psl []string, single, hyphenate, itself, hacks, fuse bool, minLength int) []string {
 	var domains []string
+	EachCombination(prefixes, suffixes, psl, single, hyphenate, itself, hacks, fuse, minLength, func(domain string) bool {
+		domains = append(domains, domain)
+		return true
+	})
+	return domains
+}
+
+// EachCombination calls yield with each domain Combine would return, in the same order, without building the list.
+// It stops as soon as yield returns false, and returns false then.
+func EachCombination(prefixes, suffixes, psl []string, single, hyphenate, itself, hacks, fuse bool, minLength int,
+	yield func(domain string) bool) bool {
 	if single {
 		for _, prefix := range prefixes {
-			domains = append(domains, CombinePhraseAndPublicSuffixes(prefix, psl, hacks)...)
+			if !eachPhraseDomain(prefix, psl, hacks, yield) {
+				return false
+			}
 		}
 		for _, suffix := range suffixes {
-			domains = append(domains, CombinePhraseAndPublicSuffixes(suffix, psl, hacks)...)
+			if !eachPhraseDomain(suffix, psl, hacks, yield) {
+				return false
+			}
 		}
 	}
 	for _, prefix := range prefixes {
 		for _, suffix := range suffixes {
 			phrases := CombinePrefixAndSuffix(prefix, suffix, itself, hyphenate, fuse, minLength)
 			for _, phrase := range phrases {
-				domains = append(domains, CombinePhraseAndPublicSuffixes(phrase, psl, hacks)...)
+				if !eachPhraseDomain(phrase, psl, hacks, yield) {
+					return false
+				}
 			}
 		}
 	}
-	return domains
+	return true
 }
 
 // FilterMaxLength filter domains surpasing the maxLengh limit.
 func FilterMaxLength(domains []string, maxLength int) []string {
 	var output []string
 	for _, domain := range domains {
-		if utf8.RuneCountInString(domain) <= maxLength {
+		if FitsMaxLength(domain, maxLength) {
 			output = append(output, domain)
 		}
 	}
 	return output
 }
 
+// FitsMaxLength returns true if domain has no more than maxLength characters
+func FitsMaxLength(domain string, maxLength int) bool {
+	return utf8.RuneCountInString(domain) <= maxLength
+}
+
 // FilterStrictDomains filter out domains possibly forbidden by registrars
 func FilterStrictDomains(domains []string, publicSuffixes map[string]bool) []string {
 	var output []string
 	for _, domain := range domains {
-		first := strings.Index(domain, ".")
-		cleanedDomain := domain[:first]
-		if _, ok := publicSuffixes[cleanedDomain]; !ok {
+		if !Prohibited(domain, publicSuffixes) {
 			output = append(output, domain)
 		}
 	}
 	return output
 }
+
+// Prohibited returns true if domain is possibly forbidden by registrars, like when its first label is a public suffix
+func Prohibited(domain string, publicSuffixes map[string]bool) bool {
+	first := strings.Index(domain, ".")
+	_, ok := publicSuffixes[domain[:first]]
+	return ok
+}
@@ -173,3 +173,16 @@ func TestFilterStrictDomains(t *testing.T) {
 		t.Errorf(tests.ErrFmtExpectedGot, "FilterStrictDomains", expected, domains)
 	}
 }
+
+func TestEachCombinationStops(t *testing.T) {
+	var domains []string
+	complete := EachCombination([]string{"go", "py"}, []string{"lang"}, []string{"com", "net"}, true, false, false,
+		false, false, 3, func(domain string) bool {
+			domains = append(domains, domain)
+			return len(domains) < 3
+		})
+	expected := []string{"go.com", "go.net", "py.com"}
+	if complete || !reflect.DeepEqual(domains, expected) {
+		t.Errorf(tests.ErrFmtExpectedGot, "EachCombination", expected, domains)
+	}
+}
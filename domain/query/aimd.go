@@ -0,0 +1,130 @@
+package query
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Defaults for AIMDChecker
+const (
+	DefaultAIMDIncrease = 1.0
+	DefaultAIMDDecrease = 0.5
+)
+
+// AIMDChecker is a Checker that adapts how many checks of Checker run at once, between Min and Max. The limit grows
+// by Increase for every limit-worth of clean checks (additive increase), and is multiplied by Decrease when a check
+// is congested, that is, a query timed out or was refused (multiplicative decrease). Congested checks that
+// started before the last decrease are ignored, so a burst of failures only counts once.
+type AIMDChecker struct {
+	Checker  Checker
+	Min, Max int
+	Increase float64
+	Decrease float64
+
+	mu           sync.Mutex
+	limit        float64
+	active       int
+	lastDecrease time.Time
+	wake         chan struct{}
+}
+
+// NewAIMDChecker returns an AIMDChecker running up to max checks of checker at once, starting at half of that
+func NewAIMDChecker(checker Checker, max int) *AIMDChecker {
+	if max < 1 {
+		max = 1
+	}
+	return &AIMDChecker{
+		Checker:  checker,
+		Min:      1,
+		Max:      max,
+		Increase: DefaultAIMDIncrease,
+		Decrease: DefaultAIMDDecrease,
+		limit:    float64(max+1) / 2,
+		wake:     make(chan struct{}),
+	}
+}
+
+// Check implements Checker, waiting for the limit to allow one more check
+func (c *AIMDChecker) Check(ctx context.Context, domain string) Result {
+	if err := c.acquire(ctx); err != nil {
+		return Result{Domain: domain, Status: StatusError, Err: err}
+	}
+	started := time.Now()
+	r := c.Checker.Check(ctx, domain)
+	c.release(r, started)
+	return r
+}
+
+// Limit returns how many checks may run at once right now
+func (c *AIMDChecker) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+func (c *AIMDChecker) acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.active < int(c.limit) {
+			c.active++
+			c.mu.Unlock()
+			return nil
+		}
+		wake := c.wake
+		c.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *AIMDChecker) release(r Result, started time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	switch {
+	case congested(r):
+		if started.After(c.lastDecrease) {
+			c.limit *= c.Decrease
+			c.lastDecrease = time.Now()
+		}
+	default:
+		c.limit += c.Increase / c.limit
+	}
+	if c.limit < float64(c.Min) {
+		c.limit = float64(c.Min)
+	}
+	if c.limit > float64(c.Max) {
+		c.limit = float64(c.Max)
+	}
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// congested tells if r shows signs of too much load: a query of it, or of any of its Answers, timed out or was
+// refused. Attempts are left out, as quorums and chains add them up without any failure.
+func congested(r Result) bool {
+	if overloaded(r.Err, r.Rcode) {
+		return true
+	}
+	for _, answer := range r.Answers {
+		if overloaded(answer.Err, answer.Rcode) {
+			return true
+		}
+	}
+	return false
+}
+
+// overloaded tells if a query failed with err and rcode because of too much load
+func overloaded(err error, rcode int) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return rcode == dns.RcodeRefused
+}
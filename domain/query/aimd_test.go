@@ -0,0 +1,139 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// loadChecker fails with REFUSED while more than capacity checks run at once, and records the most it has seen
+type loadChecker struct {
+	capacity int
+
+	mu          sync.Mutex
+	active, max int
+}
+
+func (l *loadChecker) Check(ctx context.Context, domain string) Result {
+	l.mu.Lock()
+	l.active++
+	if l.active > l.max {
+		l.max = l.active
+	}
+	overloaded := l.active > l.capacity
+	l.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	if overloaded {
+		return DefaultRcodePolicy.apply(Result{Domain: domain, Rcode: dns.RcodeRefused, Attempts: 1})
+	}
+	return Result{Domain: domain, Status: StatusAvailable, Rcode: dns.RcodeNameError, Attempts: 1}
+}
+
+func TestAIMDCheckerAdjustsLimit(t *testing.T) {
+	c := NewAIMDChecker(&fakeChecker{rcode: dns.RcodeNameError}, 10)
+	if c.Limit() != 5 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "initial Limit", 5, c.Limit())
+	}
+	for i := 0; i < 6; i++ {
+		c.Check(context.Background(), "example.com")
+	}
+	if c.Limit() != 6 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after a clean round", 6, c.Limit())
+	}
+	for i := 0; i < 100; i++ {
+		c.Check(context.Background(), "example.com")
+	}
+	if c.Limit() != 10 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after many clean rounds", 10, c.Limit())
+	}
+
+	before := time.Now()
+	c.Check(context.Background(), "example.com")
+	c.Checker = &fakeChecker{rcode: dns.RcodeRefused}
+	c.Check(context.Background(), "example.com")
+	if c.Limit() != 5 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after REFUSED", 5, c.Limit())
+	}
+	// congested checks started before the last decrease don't count again
+	c.acquire(context.Background())
+	c.release(Result{Rcode: dns.RcodeRefused, Err: &rcodeError{dns.RcodeRefused, true}}, before)
+	if c.Limit() != 5 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after an old REFUSED", 5, c.Limit())
+	}
+	for i := 0; i < 5; i++ {
+		c.Check(context.Background(), "example.com")
+	}
+	if c.Limit() != c.Min {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after many REFUSED", c.Min, c.Limit())
+	}
+}
+
+func TestAIMDCheckerLimitsConcurrency(t *testing.T) {
+	load := &loadChecker{capacity: 4}
+	c := NewAIMDChecker(load, 16)
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c.Check(context.Background(), "example.com")
+			}
+		}()
+	}
+	wg.Wait()
+	if load.max > c.Max {
+		t.Errorf(tests.ErrFmtExpectedGotV, "most checks at once", c.Max, load.max)
+	}
+	if c.Limit() >= c.Max {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit below Max", c.Max-1, c.Limit())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.limit = 0
+	if r := c.Check(ctx, "example.com"); r.Err != context.Canceled {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Check", context.Canceled, r.Err)
+	}
+}
+
+// resultChecker always returns r
+type resultChecker struct {
+	r Result
+}
+
+func (c resultChecker) Check(ctx context.Context, domain string) Result {
+	return c.r
+}
+
+func TestAIMDCheckerIgnoresAttemptsOfQuorumsAndChains(t *testing.T) {
+	clean := Result{Status: StatusAvailable, Rcode: dns.RcodeNameError, Attempts: 1}
+	voted := Result{Status: StatusAvailable, Rcode: dns.RcodeNameError, Attempts: 3, Answers: []Answer{
+		{Server: "a", Status: StatusAvailable, Rcode: dns.RcodeNameError},
+		{Server: "b", Status: StatusAvailable, Rcode: dns.RcodeNameError},
+		{Server: "c", Status: StatusAvailable, Rcode: dns.RcodeNameError},
+	}}
+	for _, checker := range []Checker{resultChecker{voted}, Chain{resultChecker{clean}, resultChecker{clean}}} {
+		c := NewAIMDChecker(checker, 50)
+		for i := 0; i < 50; i++ {
+			c.Check(context.Background(), "example.com")
+		}
+		if c.Limit() <= 25 {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Limit after clean results with many attempts", "> 25", c.Limit())
+		}
+	}
+
+	voted.Answers[1] = Answer{Server: "b", Status: StatusUnknown, Rcode: dns.RcodeRefused}
+	c := NewAIMDChecker(resultChecker{voted}, 50)
+	c.Check(context.Background(), "example.com")
+	if c.Limit() != 12 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Limit after a REFUSED voter", 12, c.Limit())
+	}
+}
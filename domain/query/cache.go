@@ -0,0 +1,224 @@
+package query
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Default TTLs for cached Results
+const (
+	DefaultRegisteredTTL = 7 * 24 * time.Hour
+	DefaultAvailableTTL  = 24 * time.Hour
+)
+
+// Ways of checking domains besides plain DNS queries. Cached Results record the ones they went through, so they are
+// not served to a stricter check.
+const (
+	CheckDNSSEC    = "dnssec"
+	CheckRDAP      = "rdap"
+	CheckWHOIS     = "whois"
+	CheckProbe     = "probe"
+	CheckQuorum    = "quorum"
+	CheckIterative = "iterative"
+)
+
+// cacheEntry is a Result as stored in the cache file, one JSON object per line
+type cacheEntry struct {
+	Domain         string    `json:"domain"`
+	Rcode          int       `json:"rcode"`
+	Status         string    `json:"state"`
+	Checks         []string  `json:"checks,omitempty"` // how the Result was checked, besides plain DNS queries
+	Proven         bool      `json:"proven,omitempty"`
+	Presence       string    `json:"presence,omitempty"`
+	RegistryStatus []string  `json:"status,omitempty"`
+	Expires        time.Time `json:"expires"`
+}
+
+// Cache is an on-disk store of final Results keyed by domain. Registered and available domains expire after their
+// own TTLs, and NXDOMAIN answers don't outlive the negative TTL from the SOA record of the zone, when it is known.
+type Cache struct {
+	RegisteredTTL time.Duration
+	AvailableTTL  time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]cacheEntry
+}
+
+// OpenCache loads the cache file at path, creating it if needed. Expired entries are dropped from the file.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{
+		RegisteredTTL: DefaultRegisteredTTL,
+		AvailableTTL:  DefaultAvailableTTL,
+		entries:       map[string]cacheEntry{},
+	}
+	if err := c.load(path); err != nil {
+		return nil, err
+	}
+	if err := c.compact(path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+// load reads every entry still valid from path. Later entries replace earlier ones, and broken lines are skipped.
+func (c *Cache) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	now := time.Now()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry cacheEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Domain == "" {
+			continue
+		}
+		if _, ok := parseStatus(entry.Status); !ok {
+			continue
+		}
+		if entry.Expires.After(now) {
+			c.entries[entry.Domain] = entry
+		} else {
+			delete(c.entries, entry.Domain)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites path with the loaded entries only
+func (c *Cache) compact(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range c.entries {
+		if err := encoder.Encode(entry); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Len returns the number of cached domains
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns the cached Result for domain, if it did not expire yet and went through every one of checks
+func (c *Cache) Get(domain string, checks []string) (Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[domain]
+	if !ok || !entry.Expires.After(time.Now()) {
+		return Result{}, false
+	}
+	for _, check := range checks {
+		if !contains(entry.Checks, check) {
+			return Result{}, false
+		}
+	}
+	status, _ := parseStatus(entry.Status)
+	r := Result{
+		Domain:         entry.Domain,
+		Status:         status,
+		Rcode:          entry.Rcode,
+		RegistryStatus: entry.RegistryStatus,
+		Cached:         true,
+		Proven:         entry.Proven,
+		Presence:       parsePresence(entry.Presence),
+	}
+	return r, true
+}
+
+// Put stores a final Result, that went through checks. Unknown Results are not cached.
+func (c *Cache) Put(r Result, checks []string) error {
+	if r.Unknown() {
+		return nil
+	}
+	ttl := c.RegisteredTTL
+	if r.Available() {
+		ttl = c.AvailableTTL
+		if r.negativeTTL > 0 && r.negativeTTL < ttl {
+			ttl = r.negativeTTL
+		}
+	}
+	entry := cacheEntry{
+		Domain:         r.Domain,
+		Rcode:          r.Rcode,
+		Status:         r.Status.String(),
+		Checks:         checks,
+		Proven:         r.Proven,
+		Presence:       r.Presence.String(),
+		RegistryStatus: r.RegistryStatus,
+		Expires:        time.Now().Add(ttl),
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[r.Domain] = entry
+	_, err = c.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close the cache file
+func (c *Cache) Close() error {
+	return c.file.Close()
+}
+
+// CachedChecker is a Checker that answers from Cache when it can, and stores the final Results of Checker there.
+// With Refresh set every domain is checked again, and the cache only gets updated. Checks tell how Checker checks
+// domains, so Results checked in fewer ways are not served. The cache is best effort, so failing to store a Result
+// does not fail the check.
+type CachedChecker struct {
+	Checker Checker
+	Cache   *Cache
+	Refresh bool
+	Checks  []string // like CheckDNSSEC, CheckRDAP, CheckWHOIS, CheckProbe, CheckQuorum or CheckIterative
+}
+
+// NewCachedChecker returns a CachedChecker wrapping checker with cache
+func NewCachedChecker(checker Checker, cache *Cache) *CachedChecker {
+	return &CachedChecker{Checker: checker, Cache: cache}
+}
+
+// Check implements Checker
+func (c *CachedChecker) Check(ctx context.Context, domain string) Result {
+	if !c.Refresh {
+		if r, ok := c.Cache.Get(domain, c.Checks); ok {
+			return r
+		}
+	}
+	r := c.Checker.Check(ctx, domain)
+	c.Cache.Put(r, c.Checks)
+	return r
+}
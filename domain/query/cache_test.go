@@ -0,0 +1,122 @@
+package query
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func setupTestCache(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "domainerator.cache.test.")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "TempDir", err, dir)
+	}
+	return filepath.Join(dir, "cache"), func() { os.RemoveAll(dir) }
+}
+
+func TestCachePersistsResults(t *testing.T) {
+	path, cleanup := setupTestCache(t)
+	defer cleanup()
+	cache, err := OpenCache(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "OpenCache", err, path)
+	}
+	cache.Put(Result{Domain: "taken.com", Status: StatusRegistered, Rcode: dns.RcodeSuccess,
+		RegistryStatus: []string{"active"}}, nil)
+	cache.Put(Result{Domain: "free.com", Status: StatusAvailable, Rcode: dns.RcodeNameError,
+		negativeTTL: time.Nanosecond}, nil)
+	cache.Put(Result{Domain: "broken.com", Status: StatusError, Rcode: dns.RcodeServerFailure, Err: ErrUnsupported}, nil)
+	cache.Close()
+
+	time.Sleep(time.Millisecond)
+	cache, err = OpenCache(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "OpenCache", err, path)
+	}
+	defer cache.Close()
+	if cache.Len() != 1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Len", 1, cache.Len())
+	}
+	r, ok := cache.Get("taken.com", nil)
+	if !ok || r.Available() || !r.Cached || len(r.RegistryStatus) != 1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "cached taken.com", r)
+	}
+	if _, ok := cache.Get("free.com", nil); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "expired free.com", ok)
+	}
+	if _, ok := cache.Get("broken.com", nil); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "uncached broken.com", ok)
+	}
+}
+
+func TestCacheKeepsHowResultsWereChecked(t *testing.T) {
+	path, cleanup := setupTestCache(t)
+	defer cleanup()
+	cache, err := OpenCache(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "OpenCache", err, path)
+	}
+	cache.Put(Result{Domain: "free.com", Status: StatusAvailable, Rcode: dns.RcodeNameError, Proven: true},
+		[]string{CheckDNSSEC})
+	cache.Put(Result{Domain: "held.com", Status: StatusOnHold, Rcode: dns.RcodeSuccess}, []string{CheckRDAP})
+	cache.Put(Result{Domain: "parked.com", Status: StatusRegistered, Rcode: dns.RcodeSuccess, Presence: PresenceParked},
+		[]string{CheckProbe})
+	cache.Close()
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(content), `"state":"ONHOLD"`) {
+		t.Errorf(tests.ErrFmtExpectedGot, "Put", `"state":"ONHOLD"`, string(content))
+	}
+	cache, err = OpenCache(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "OpenCache", err, path)
+	}
+	defer cache.Close()
+	if r, ok := cache.Get("free.com", []string{CheckDNSSEC}); !ok || !r.Available() || !r.Proven {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "proven free.com", r)
+	}
+	if r, ok := cache.Get("held.com", nil); !ok || r.Status != StatusOnHold {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "held.com on hold", r)
+	}
+	if _, ok := cache.Get("free.com", []string{CheckDNSSEC, CheckRDAP}); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "free.com not served to a stricter check", ok)
+	}
+	if r, ok := cache.Get("parked.com", []string{CheckProbe}); !ok || r.Presence != PresenceParked {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "parked.com parked", r)
+	}
+	if _, ok := cache.Get("held.com", []string{CheckProbe}); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Get", "held.com not served to a probing check", ok)
+	}
+}
+
+func TestCachedChecker(t *testing.T) {
+	path, cleanup := setupTestCache(t)
+	defer cleanup()
+	cache, err := OpenCache(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "OpenCache", err, path)
+	}
+	defer cache.Close()
+	fake := &fakeChecker{rcode: dns.RcodeNameError}
+	checker := NewCachedChecker(fake, cache)
+	for i := 0; i < 3; i++ {
+		if r := checker.Check(context.Background(), "free.com"); !r.Available() {
+			t.Errorf(tests.ErrFmtExpectedGotV, "CachedChecker.Check", "available", r)
+		}
+	}
+	if fake.calls != 1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "CachedChecker.Check", 1, fake.calls)
+	}
+	checker.Refresh = true
+	checker.Check(context.Background(), "free.com")
+	if fake.calls != 2 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "CachedChecker.Check", 2, fake.calls)
+	}
+}
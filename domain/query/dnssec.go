@@ -0,0 +1,500 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RootTrustAnchors are the DS records of the root zone KSKs published by IANA (KSK-2017 and KSK-2024)
+const RootTrustAnchors = `
+. IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+. IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16
+`
+
+// maxNSEC3Iterations limits the work spent hashing names for NSEC3 records (RFC 9276 recommends zero)
+const maxNSEC3Iterations = 150
+
+// ErrBogus is reported when a DNSSEC signed answer does not validate, which means it was forged or broken on the way
+var ErrBogus = errors.New("DNSSEC validation failed")
+
+// ParseTrustAnchors parses DS records of the root zone, one per line in zone file format
+func ParseTrustAnchors(content string) ([]*dns.DS, error) {
+	var anchors []*dns.DS
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trust anchor %q: %s", line, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok || ds.Hdr.Name != "." {
+			return nil, fmt.Errorf("Trust anchor %q is not a DS record of the root zone", line)
+		}
+		anchors = append(anchors, ds)
+	}
+	if len(anchors) == 0 {
+		return nil, errors.New("No trust anchors found")
+	}
+	return anchors, nil
+}
+
+// LoadTrustAnchors reads DS records of the root zone from a file
+func LoadTrustAnchors(path string) ([]*dns.DS, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrustAnchors(string(content))
+}
+
+// exchangeFunc asks a DNS server for the name/qtype records with the DO bit set
+type exchangeFunc func(name string, qtype uint16) (*dns.Msg, error)
+
+// zoneKeys are the validated DNSKEYs of a zone. Unsigned zones are not secure and have no keys.
+type zoneKeys struct {
+	secure bool
+	keys   []*dns.DNSKEY
+}
+
+// DNSSECValidator checks the NSEC/NSEC3 records of NXDOMAIN answers. The keys of each zone are validated through DS
+// records up to the root zone keys matching TrustAnchors, and kept for the whole run.
+type DNSSECValidator struct {
+	TrustAnchors []*dns.DS
+
+	mu    sync.Mutex
+	zones map[string]*zoneKeys
+}
+
+// NewDNSSECValidator returns a DNSSECValidator trusting anchors
+func NewDNSSECValidator(anchors []*dns.DS) *DNSSECValidator {
+	return &DNSSECValidator{TrustAnchors: anchors, zones: map[string]*zoneKeys{}}
+}
+
+// ValidateNXDOMAIN returns true if the authority section of in proves that name does not exist. Answers from
+// unsigned zones, or relying on NSEC3 opt-out, prove nothing and return false. Answers from signed zones that don't
+// validate return ErrBogus.
+func (v *DNSSECValidator) ValidateNXDOMAIN(name string, in *dns.Msg, ex exchangeFunc) (bool, error) {
+	name = strings.ToLower(dns.Fqdn(name))
+	rrsets, sigs := splitRRsets(in.Ns)
+	zone := signerZone(sigs, name)
+	if zone == "" {
+		// nothing signed, which is fine only if the zone of the SOA record is not signed either
+		zone = soaZone(in.Ns)
+		if zone == "" {
+			return false, nil
+		}
+		keys, err := v.zoneKeys(zone, ex)
+		if err != nil {
+			return false, err
+		}
+		if keys.secure {
+			return false, fmt.Errorf("%s: unsigned NXDOMAIN from signed zone %q", ErrBogus, zone)
+		}
+		return false, nil
+	}
+	keys, err := v.zoneKeys(zone, ex)
+	if err != nil || !keys.secure {
+		return false, err
+	}
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for key, rrset := range rrsets {
+		if key.qtype != dns.TypeNSEC && key.qtype != dns.TypeNSEC3 {
+			continue
+		}
+		if err := verifyRRset(rrset, sigs[key], keys.keys); err != nil {
+			return false, fmt.Errorf("%s: %s %s: %s", ErrBogus, key.name, dns.TypeToString[key.qtype], err)
+		}
+		for _, rr := range rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			}
+		}
+	}
+	if len(nsecs) > 0 {
+		if !nsecProvesNXDOMAIN(name, zone, nsecs) {
+			return false, fmt.Errorf("%s: NSEC records don't prove %q does not exist", ErrBogus, name)
+		}
+		return true, nil
+	}
+	if len(nsec3s) > 0 {
+		return nsec3ProvesNXDOMAIN(name, zone, nsec3s)
+	}
+	return false, fmt.Errorf("%s: no NSEC or NSEC3 records for %q", ErrBogus, name)
+}
+
+// zoneKeys returns the validated keys of zone, fetching and validating them on first use
+func (v *DNSSECValidator) zoneKeys(zone string, ex exchangeFunc) (*zoneKeys, error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+	v.mu.Lock()
+	keys, ok := v.zones[zone]
+	v.mu.Unlock()
+	if ok {
+		return keys, nil
+	}
+	keys, err := v.fetchZoneKeys(zone, ex)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	v.zones[zone] = keys
+	v.mu.Unlock()
+	return keys, nil
+}
+
+func (v *DNSSECValidator) fetchZoneKeys(zone string, ex exchangeFunc) (*zoneKeys, error) {
+	trusted := v.TrustAnchors
+	if zone != "." {
+		in, err := ex(zone, dns.TypeDS)
+		if err != nil {
+			return nil, err
+		}
+		rrsets, sigs := splitRRsets(in.Answer)
+		key := rrsetKey{zone, dns.TypeDS}
+		if len(rrsets[key]) == 0 {
+			return v.provenUnsigned(zone, in, ex)
+		}
+		parent := signerZone(sigs, zone)
+		if parent == "" || parent == zone {
+			return nil, fmt.Errorf("%s: unsigned DS records for %q", ErrBogus, zone)
+		}
+		parentKeys, err := v.zoneKeys(parent, ex)
+		if err != nil {
+			return nil, err
+		}
+		if !parentKeys.secure {
+			return &zoneKeys{}, nil
+		}
+		if err := verifyRRset(rrsets[key], sigs[key], parentKeys.keys); err != nil {
+			return nil, fmt.Errorf("%s: DS records for %q: %s", ErrBogus, zone, err)
+		}
+		trusted = nil
+		for _, rr := range rrsets[key] {
+			trusted = append(trusted, rr.(*dns.DS))
+		}
+	}
+
+	in, err := ex(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	rrsets, sigs := splitRRsets(in.Answer)
+	key := rrsetKey{zone, dns.TypeDNSKEY}
+	var keys []*dns.DNSKEY
+	for _, rr := range rrsets[key] {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+	for _, ksk := range keys {
+		if !matchesDS(ksk, trusted) {
+			continue
+		}
+		if verifyRRset(rrsets[key], sigs[key], []*dns.DNSKEY{ksk}) == nil {
+			return &zoneKeys{secure: true, keys: keys}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no DNSKEY of %q matches its DS records", ErrBogus, zone)
+}
+
+// provenUnsigned accepts zone as unsigned if its parent is unsigned too, or if the parent proves it has no DS records
+func (v *DNSSECValidator) provenUnsigned(zone string, in *dns.Msg, ex exchangeFunc) (*zoneKeys, error) {
+	rrsets, sigs := splitRRsets(in.Ns)
+	parent := signerZone(sigs, zone)
+	if parent == "" {
+		parent = soaZone(in.Ns)
+	}
+	if parent == "" || parent == zone {
+		if zone == "." {
+			return nil, fmt.Errorf("%s: no DS records for the root zone", ErrBogus)
+		}
+		parent = parentZone(zone)
+	}
+	parentKeys, err := v.zoneKeys(parent, ex)
+	if err != nil {
+		return nil, err
+	}
+	if !parentKeys.secure {
+		return &zoneKeys{}, nil
+	}
+	for key, rrset := range rrsets {
+		if key.qtype != dns.TypeNSEC && key.qtype != dns.TypeNSEC3 {
+			continue
+		}
+		if verifyRRset(rrset, sigs[key], parentKeys.keys) != nil {
+			continue
+		}
+		for _, rr := range rrset {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				if equalNames(rr.Hdr.Name, zone) && !hasType(rr.TypeBitMap, dns.TypeDS) {
+					return &zoneKeys{}, nil
+				}
+			case *dns.NSEC3:
+				if nsec3Usable(rr) && nsec3Matches(rr, zone) && !hasType(rr.TypeBitMap, dns.TypeDS) {
+					return &zoneKeys{}, nil
+				}
+				if nsec3Usable(rr) && nsec3Covers(rr, zone) && rr.Flags&1 == 1 {
+					return &zoneKeys{}, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: missing DS records for %q are not proven", ErrBogus, zone)
+}
+
+// nsecProvesNXDOMAIN implements RFC 4035, section 5.4: an NSEC record must cover name, and another one must cover
+// the wildcard at its closest encloser
+func nsecProvesNXDOMAIN(name, zone string, nsecs []*dns.NSEC) bool {
+	var encloser string
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name, zone) {
+			encloser = longestName(commonAncestor(name, nsec.Hdr.Name), commonAncestor(name, nsec.NextDomain))
+			break
+		}
+	}
+	if encloser == "" {
+		return false
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, wildcardOf(encloser), zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecCovers tells if name sorts strictly between the owner and the next name of nsec. The last NSEC of a zone
+// points back to its apex.
+func nsecCovers(nsec *dns.NSEC, name, zone string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if canonicalCompare(owner, name) >= 0 {
+		return false
+	}
+	return canonicalCompare(name, next) < 0 || equalNames(next, zone)
+}
+
+// nsec3ProvesNXDOMAIN implements RFC 5155, section 8.4: an NSEC3 record must match the closest encloser of name, and
+// others must cover the next closer name and the wildcard at the closest encloser. Opt-out records covering the next
+// closer name may hide unsigned delegations, so they prove nothing.
+func nsec3ProvesNXDOMAIN(name, zone string, nsec3s []*dns.NSEC3) (bool, error) {
+	var usable []*dns.NSEC3
+	for _, nsec3 := range nsec3s {
+		if nsec3Usable(nsec3) {
+			usable = append(usable, nsec3)
+		}
+	}
+	if len(usable) == 0 {
+		return false, nil
+	}
+	encloser, nextCloser := "", name
+	for candidate := name; dns.IsSubDomain(zone, candidate); candidate = parentZone(candidate) {
+		for _, nsec3 := range usable {
+			if nsec3Matches(nsec3, candidate) {
+				encloser = candidate
+				break
+			}
+		}
+		if encloser != "" || candidate == "." {
+			break
+		}
+		nextCloser = candidate
+	}
+	if encloser == "" || encloser == name {
+		return false, fmt.Errorf("%s: NSEC3 records have no closest encloser for %q", ErrBogus, name)
+	}
+	var coverNextCloser, coverWildcard *dns.NSEC3
+	for _, nsec3 := range usable {
+		if nsec3Covers(nsec3, nextCloser) {
+			coverNextCloser = nsec3
+		}
+		if nsec3Covers(nsec3, wildcardOf(encloser)) {
+			coverWildcard = nsec3
+		}
+	}
+	if coverNextCloser == nil || coverWildcard == nil {
+		return false, fmt.Errorf("%s: NSEC3 records don't prove %q does not exist", ErrBogus, name)
+	}
+	if coverNextCloser.Flags&1 == 1 {
+		return false, nil
+	}
+	return true, nil
+}
+
+func nsec3Usable(nsec3 *dns.NSEC3) bool {
+	return nsec3.Hash == dns.SHA1 && nsec3.Iterations <= maxNSEC3Iterations
+}
+
+// nsec3Hash returns the owner hash of nsec3 and the hash of name with its parameters, both in upper case
+func nsec3Hash(nsec3 *dns.NSEC3, name string) (string, string) {
+	owner := strings.ToUpper(strings.SplitN(nsec3.Hdr.Name, ".", 2)[0])
+	return owner, dns.HashName(name, nsec3.Hash, nsec3.Iterations, nsec3.Salt)
+}
+
+func nsec3Matches(nsec3 *dns.NSEC3, name string) bool {
+	owner, hash := nsec3Hash(nsec3, name)
+	return owner == hash
+}
+
+func nsec3Covers(nsec3 *dns.NSEC3, name string) bool {
+	owner, hash := nsec3Hash(nsec3, name)
+	next := strings.ToUpper(nsec3.NextDomain)
+	if owner < next {
+		return owner < hash && hash < next
+	}
+	// last record of the chain, wrapping around to the first one
+	return owner < hash || hash < next
+}
+
+// rrsetKey identifies a RRset in a message section
+type rrsetKey struct {
+	name  string
+	qtype uint16
+}
+
+// splitRRsets groups records by owner and type, with RRSIG records grouped by the type they cover
+func splitRRsets(rrs []dns.RR) (map[rrsetKey][]dns.RR, map[rrsetKey][]*dns.RRSIG) {
+	rrsets := map[rrsetKey][]dns.RR{}
+	sigs := map[rrsetKey][]*dns.RRSIG{}
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name, sig.TypeCovered}
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		key := rrsetKey{name, rr.Header().Rrtype}
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	return rrsets, sigs
+}
+
+// verifyRRset returns nil if one of sigs is a current signature of rrset by one of keys
+func verifyRRset(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) error {
+	if len(sigs) == 0 {
+		return errors.New("no signatures")
+	}
+	err := errors.New("no matching key")
+	now := time.Now()
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			err = errors.New("signature expired or not yet valid")
+			continue
+		}
+		for _, key := range keys {
+			if sig.KeyTag != key.KeyTag() || sig.Algorithm != key.Algorithm {
+				continue
+			}
+			if err = sig.Verify(key, rrset); err == nil {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// matchesDS tells if key is the one referred by any of the DS records
+func matchesDS(key *dns.DNSKEY, records []*dns.DS) bool {
+	for _, ds := range records {
+		if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+			continue
+		}
+		digest := key.ToDS(ds.DigestType)
+		if digest != nil && strings.EqualFold(digest.Digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// signerZone returns the zone that signed sigs, if it is an ancestor of name
+func signerZone(sigs map[rrsetKey][]*dns.RRSIG, name string) string {
+	for _, list := range sigs {
+		for _, sig := range list {
+			signer := strings.ToLower(dns.Fqdn(sig.SignerName))
+			if dns.IsSubDomain(signer, name) {
+				return signer
+			}
+		}
+	}
+	return ""
+}
+
+// soaZone returns the owner of the first SOA record in rrs
+func soaZone(rrs []dns.RR) string {
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return strings.ToLower(dns.Fqdn(soa.Hdr.Name))
+		}
+	}
+	return ""
+}
+
+func parentZone(name string) string {
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= 1 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+func wildcardOf(name string) string {
+	if name == "." {
+		return "*."
+	}
+	return "*." + name
+}
+
+func hasType(bitmap []uint16, qtype uint16) bool {
+	for _, t := range bitmap {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+func equalNames(a, b string) bool {
+	return strings.EqualFold(dns.Fqdn(a), dns.Fqdn(b))
+}
+
+// commonAncestor returns the longest name both a and b are part of
+func commonAncestor(a, b string) string {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+	n := 0
+	for n < len(la) && n < len(lb) && la[len(la)-1-n] == lb[len(lb)-1-n] {
+		n++
+	}
+	if n == 0 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(la[len(la)-n:], "."))
+}
+
+func longestName(a, b string) string {
+	if dns.CountLabel(b) > dns.CountLabel(a) {
+		return b
+	}
+	return a
+}
+
+// canonicalCompare orders names as RFC 4034, section 6.1 requires: label by label from the right, case insensitive
+func canonicalCompare(a, b string) int {
+	la, lb := dns.SplitDomainName(strings.ToLower(a)), dns.SplitDomainName(strings.ToLower(b))
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		if c := strings.Compare(la[len(la)-i], lb[len(lb)-i]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
@@ -0,0 +1,312 @@
+package query
+
+import (
+	"crypto"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+const (
+	testNSEC3Salt       = "AABB"
+	testNSEC3Iterations = 1
+)
+
+// signedZone is a zone with a single key, used both as KSK and ZSK
+type signedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSignedZone(t *testing.T, name string) *signedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Generate", "No Error", err)
+	}
+	return &signedZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign returns rrset followed by its signature
+func (z *signedZone) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	now := time.Now()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: 3600},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.priv, rrset); err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Sign", "No Error", err)
+	}
+	return append(rrset, sig)
+}
+
+func (z *signedZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+func (z *signedZone) soa() dns.RR {
+	return mustRR(z.name + " 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300")
+}
+
+func mustRR(s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		panic(err)
+	}
+	return rr
+}
+
+// nsecChain returns the signed NSEC records of zone for names (the apex included)
+func nsecChain(t *testing.T, z *signedZone, names []string) []dns.RR {
+	sort.Slice(names, func(i, j int) bool { return canonicalCompare(names[i], names[j]) < 0 })
+	var rrs []dns.RR
+	for i, name := range names {
+		next := names[(i+1)%len(names)]
+		types := []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC}
+		nsec := &dns.NSEC{
+			Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: next,
+			TypeBitMap: types,
+		}
+		rrs = append(rrs, z.sign(t, nsec)...)
+	}
+	return rrs
+}
+
+// nsec3Chain returns the signed NSEC3 records of zone for names (the apex included)
+func nsec3Chain(t *testing.T, z *signedZone, names []string, flags uint8) []dns.RR {
+	var hashes []string
+	for _, name := range names {
+		hashes = append(hashes, dns.HashName(name, dns.SHA1, testNSEC3Iterations, testNSEC3Salt))
+	}
+	sort.Strings(hashes)
+	var rrs []dns.RR
+	for i, hash := range hashes {
+		owner := strings.ToLower(hash) + "." + z.name
+		nsec3 := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			Iterations: testNSEC3Iterations,
+			SaltLength: uint8(len(testNSEC3Salt) / 2),
+			Salt:       testNSEC3Salt,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: []uint16{dns.TypeNS},
+		}
+		rrs = append(rrs, z.sign(t, nsec3)...)
+	}
+	return rrs
+}
+
+// dnssecTestZones is a stand-in DNS hierarchy: a signed root, a TLD signed with NSEC ("test"), one signed with
+// NSEC3 ("test3") and an unsigned one ("plain"). Only b and m exist under each TLD.
+type dnssecTestZones struct {
+	root, test, test3 *signedZone
+	answers           map[rrsetKey]*dns.Msg
+	nxdomain          map[string]*dns.Msg
+}
+
+func newDNSSECTestZones(t *testing.T) *dnssecTestZones {
+	z := &dnssecTestZones{
+		root:     newSignedZone(t, "."),
+		test:     newSignedZone(t, "test."),
+		test3:    newSignedZone(t, "test3."),
+		answers:  map[rrsetKey]*dns.Msg{},
+		nxdomain: map[string]*dns.Msg{},
+	}
+	z.answer(rrsetKey{".", dns.TypeDNSKEY}, z.root.sign(t, z.root.key), nil)
+	z.answer(rrsetKey{"test.", dns.TypeDS}, z.root.sign(t, z.test.ds()), nil)
+	z.answer(rrsetKey{"test3.", dns.TypeDS}, z.root.sign(t, z.test3.ds()), nil)
+	z.answer(rrsetKey{"test.", dns.TypeDNSKEY}, z.test.sign(t, z.test.key), nil)
+	z.answer(rrsetKey{"test3.", dns.TypeDNSKEY}, z.test3.sign(t, z.test3.key), nil)
+	plainNSEC := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "plain.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+		NextDomain: "test.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	plainNoDS := append(z.root.sign(t, z.root.soa()), z.root.sign(t, plainNSEC)...)
+	z.answer(rrsetKey{"plain.", dns.TypeDS}, nil, plainNoDS)
+
+	testNames := []string{"test.", "b.test.", "m.test."}
+	test3Names := []string{"test3.", "b.test3.", "m.test3."}
+	plainSOA := mustRR("plain. 3600 IN SOA ns.plain. admin.plain. 1 3600 600 86400 300")
+	z.nxdomain["test."] = z.nxdomainMsg(z.test.sign(t, z.test.soa()), nsecChain(t, z.test, testNames))
+	z.nxdomain["test3."] = z.nxdomainMsg(z.test3.sign(t, z.test3.soa()), nsec3Chain(t, z.test3, test3Names, 0))
+	z.nxdomain["plain."] = z.nxdomainMsg([]dns.RR{plainSOA}, nil)
+	return z
+}
+
+func (z *dnssecTestZones) answer(key rrsetKey, answer, ns []dns.RR) {
+	z.answers[key] = &dns.Msg{Answer: answer, Ns: ns}
+}
+
+func (z *dnssecTestZones) nxdomainMsg(soa, denial []dns.RR) *dns.Msg {
+	m := &dns.Msg{Ns: append(soa, denial...)}
+	m.Rcode = dns.RcodeNameError
+	return m
+}
+
+func (z *dnssecTestZones) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	m := new(dns.Msg)
+	m.SetReply(req)
+	labels := dns.SplitDomainName(q.Name)
+	switch {
+	case z.answers[rrsetKey{q.Name, q.Qtype}] != nil:
+		answer := z.answers[rrsetKey{q.Name, q.Qtype}]
+		m.Answer, m.Ns = answer.Answer, answer.Ns
+	case len(labels) == 2 && (labels[0] == "b" || labels[0] == "m"):
+		m.Answer = []dns.RR{mustRR(q.Name + " 3600 IN NS ns.example.")}
+	case len(labels) > 0 && z.nxdomain[labels[len(labels)-1]+"."] != nil:
+		nx := z.nxdomain[labels[len(labels)-1]+"."]
+		m.Rcode, m.Ns = nx.Rcode, nx.Ns
+	default:
+		m.Rcode = dns.RcodeRefused
+	}
+	w.WriteMsg(m)
+}
+
+// startDNSServer serves handler on a local UDP port and returns its address
+func startDNSServer(t testing.TB, handler dns.Handler) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ListenPacket", "No Error", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func testExchange(addr string) exchangeFunc {
+	return func(name string, qtype uint16) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		m.SetEdns0(4096, true)
+		in, _, err := new(dns.Client).Exchange(m, addr)
+		return in, err
+	}
+}
+
+func TestDNSSECValidator(t *testing.T) {
+	zones := newDNSSECTestZones(t)
+	addr, stop := startDNSServer(t, zones)
+	defer stop()
+	ex := testExchange(addr)
+	v := NewDNSSECValidator([]*dns.DS{zones.root.ds()})
+
+	for _, domain := range []string{"c.test.", "zz.test.", "a.test.", "c.test3.", "www.c.test3."} {
+		in, err := ex(domain, dns.TypeNS)
+		if err != nil {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Exchange", "No Error", err)
+		}
+		proven, err := v.ValidateNXDOMAIN(domain, in, ex)
+		if err != nil || !proven {
+			t.Errorf(tests.ErrFmtExpectedGotV, "ValidateNXDOMAIN", domain+" proven", err)
+		}
+	}
+
+	in, _ := ex("c.plain.", dns.TypeNS)
+	if proven, err := v.ValidateNXDOMAIN("c.plain.", in, ex); err != nil || proven {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ValidateNXDOMAIN", "c.plain. insecure", err)
+	}
+}
+
+func TestDNSSECValidatorRejectsForgedAnswers(t *testing.T) {
+	zones := newDNSSECTestZones(t)
+	addr, stop := startDNSServer(t, zones)
+	defer stop()
+	ex := testExchange(addr)
+	v := NewDNSSECValidator([]*dns.DS{zones.root.ds()})
+
+	// NXDOMAIN for names that exist
+	for _, domain := range []string{"b.test.", "b.test3."} {
+		in, _ := ex("c."+strings.SplitN(domain, ".", 2)[1], dns.TypeNS)
+		if _, err := v.ValidateNXDOMAIN(domain, in, ex); err == nil {
+			t.Errorf(tests.ErrFmtExpectedGot, "ValidateNXDOMAIN", "Bogus error for "+domain, "No Error")
+		}
+	}
+
+	// signatures stripped
+	in, _ := ex("c.test.", dns.TypeNS)
+	stripped := in.Copy()
+	stripped.Ns = nil
+	for _, rr := range in.Ns {
+		if _, ok := rr.(*dns.RRSIG); !ok {
+			stripped.Ns = append(stripped.Ns, rr)
+		}
+	}
+	if _, err := v.ValidateNXDOMAIN("c.test.", stripped, ex); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ValidateNXDOMAIN", "Bogus error for stripped signatures", "No Error")
+	}
+
+	// NSEC records changed after signing
+	tampered := in.Copy()
+	for _, rr := range tampered.Ns {
+		if nsec, ok := rr.(*dns.NSEC); ok {
+			nsec.NextDomain = "z.test."
+		}
+	}
+	if _, err := v.ValidateNXDOMAIN("c.test.", tampered, ex); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ValidateNXDOMAIN", "Bogus error for tampered NSEC", "No Error")
+	}
+
+	// untrusted root keys
+	other := NewDNSSECValidator([]*dns.DS{newSignedZone(t, ".").ds()})
+	if _, err := other.ValidateNXDOMAIN("c.test.", in, ex); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ValidateNXDOMAIN", "Bogus error for untrusted root", "No Error")
+	}
+}
+
+func TestNSEC3OptOutProvesNothing(t *testing.T) {
+	z := newSignedZone(t, "test3.")
+	names := []string{"test3.", "b.test3.", "m.test3."}
+	var nsec3s []*dns.NSEC3
+	for _, rr := range nsec3Chain(t, z, names, 1) {
+		if nsec3, ok := rr.(*dns.NSEC3); ok {
+			nsec3s = append(nsec3s, nsec3)
+		}
+	}
+	proven, err := nsec3ProvesNXDOMAIN("c.test3.", "test3.", nsec3s)
+	if err != nil || proven {
+		t.Errorf(tests.ErrFmtExpectedGotV, "nsec3ProvesNXDOMAIN", false, proven)
+	}
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.",
+		"z.example.", "*.z.example.", "a.z.example."}
+	for i := 1; i < len(ordered); i++ {
+		if canonicalCompare(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf(tests.ErrFmtExpectedGot, "canonicalCompare", ordered[i-1]+" < "+ordered[i], "not less")
+		}
+	}
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	anchors, err := ParseTrustAnchors(RootTrustAnchors)
+	if err != nil || len(anchors) != 2 || anchors[0].KeyTag != 20326 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseTrustAnchors", "2 root anchors", anchors)
+	}
+	comDS := "com. IN DS 30909 8 2 E2D3C916F6DEEAC73294E8268FB5885044A833FC5459588F4A9184CFC41A5766"
+	if _, err := ParseTrustAnchors(comDS); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ParseTrustAnchors", "Error for non root anchor", "No Error")
+	}
+}
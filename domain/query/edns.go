@@ -0,0 +1,149 @@
+package query
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultEDNSSize is the UDP payload size advertised in queries, small enough to avoid IP fragmentation
+const DefaultEDNSSize = 1232
+
+// EDNSTransport is a Transport adding EDNS0 options (RFC 6891) to the queries sent through Transport: the UDPSize
+// answers may have (0 for no EDNS0 unless another option needs it), DNS cookies (RFC 7873) when Cookies is set, and
+// the client subnet (RFC 7871) of Subnet, if any. Server cookies are kept by server, and queries rejected with
+// BADCOOKIE are sent once more with the cookie that came with the rejection.
+type EDNSTransport struct {
+	Transport Transport
+	UDPSize   uint16
+	Cookies   bool
+	Subnet    *net.IPNet
+
+	client  string // client cookie, in hex
+	mu      sync.Mutex
+	servers map[string]string // server cookies, in hex
+}
+
+// NewEDNSTransport returns an EDNSTransport advertising DefaultEDNSSize through transport
+func NewEDNSTransport(transport Transport) *EDNSTransport {
+	return &EDNSTransport{
+		Transport: transport,
+		UDPSize:   DefaultEDNSSize,
+		client:    randomCookie(),
+		servers:   map[string]string{},
+	}
+}
+
+// ParseClientSubnet parses a client subnet like 192.0.2.0/24 or 2001:db8::/56. Addresses without a prefix length
+// are cut to /24 (IPv4) or /56 (IPv6), so they don't give away more than a subnet.
+func ParseClientSubnet(subnet string) (*net.IPNet, error) {
+	if !strings.Contains(subnet, "/") {
+		ip := net.ParseIP(subnet)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid client subnet: %q", subnet)
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4().Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}, nil
+		}
+		return &net.IPNet{IP: ip.Mask(net.CIDRMask(56, 128)), Mask: net.CIDRMask(56, 128)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid client subnet: %q", subnet)
+	}
+	return ipnet, nil
+}
+
+// Exchange implements Transport
+func (t *EDNSTransport) Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	in, rtt, err := t.Transport.Exchange(t.prepare(m, server), server)
+	if err != nil || !t.Cookies || !t.remember(in, server) || !badCookie(in) {
+		return in, rtt, err
+	}
+	in, retryRTT, err := t.Transport.Exchange(t.prepare(m, server), server)
+	return in, rtt + retryRTT, err
+}
+
+// Proto implements Transport
+func (t *EDNSTransport) Proto(server string) string {
+	return t.Transport.Proto(server)
+}
+
+// prepare returns a copy of m with the EDNS0 options for server
+func (t *EDNSTransport) prepare(m *dns.Msg, server string) *dns.Msg {
+	if t.UDPSize == 0 && !t.Cookies && t.Subnet == nil {
+		return m
+	}
+	query := m.Copy()
+	opt := query.IsEdns0()
+	if opt == nil {
+		size := t.UDPSize
+		if size < dns.MinMsgSize {
+			size = dns.MinMsgSize
+		}
+		query.SetEdns0(size, false)
+		opt = query.IsEdns0()
+	} else if t.UDPSize > 0 {
+		opt.SetUDPSize(t.UDPSize)
+	}
+	if t.Cookies {
+		t.mu.Lock()
+		cookie := t.client + t.servers[server]
+		t.mu.Unlock()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	}
+	if t.Subnet != nil {
+		opt.Option = append(opt.Option, clientSubnet(t.Subnet))
+	}
+	return query
+}
+
+// remember keeps the server cookie in an answer from server, and tells if there was one for our client cookie
+func (t *EDNSTransport) remember(in *dns.Msg, server string) bool {
+	opt := in.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		cookie, ok := option.(*dns.EDNS0_COOKIE)
+		if !ok || len(cookie.Cookie) <= len(t.client) || !strings.EqualFold(cookie.Cookie[:len(t.client)], t.client) {
+			continue
+		}
+		t.mu.Lock()
+		t.servers[server] = cookie.Cookie[len(t.client):]
+		t.mu.Unlock()
+		return true
+	}
+	return false
+}
+
+// badCookie tells if in is a BADCOOKIE answer. Its extended rcode may not be merged into Rcode yet.
+func badCookie(in *dns.Msg) bool {
+	if in.Rcode == dns.RcodeBadCookie {
+		return true
+	}
+	opt := in.IsEdns0()
+	return opt != nil && in.Rcode == dns.RcodeBadCookie&0xF && opt.ExtendedRcode() == dns.RcodeBadCookie>>4
+}
+
+func clientSubnet(subnet *net.IPNet) *dns.EDNS0_SUBNET {
+	bits, _ := subnet.Mask.Size()
+	option := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 2, SourceNetmask: uint8(bits), Address: subnet.IP}
+	if ip := subnet.IP.To4(); ip != nil {
+		option.Family, option.Address = 1, ip
+	}
+	return option
+}
+
+// randomCookie returns 8 random bytes in hex, for a client cookie
+func randomCookie() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,144 @@
+package query
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// cookieHandler wants the server cookie "cafe0000cafe0000" after the client one, and answers BADCOOKIE with it
+// otherwise. Client subnets are echoed back with a scope.
+type cookieHandler struct {
+	mu      sync.Mutex
+	queries []*dns.Msg
+}
+
+func (h *cookieHandler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	h.mu.Lock()
+	h.queries = append(h.queries, req)
+	h.mu.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(req)
+	opt := req.IsEdns0()
+	if opt == nil {
+		w.WriteMsg(m)
+		return
+	}
+	m.SetEdns0(opt.UDPSize(), false)
+	for _, option := range opt.Option {
+		switch option := option.(type) {
+		case *dns.EDNS0_COOKIE:
+			client := option.Cookie[:16]
+			if option.Cookie != client+"cafe0000cafe0000" {
+				m.Rcode = dns.RcodeBadCookie
+			}
+			m.IsEdns0().Option = append(m.IsEdns0().Option,
+				&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: client + "cafe0000cafe0000"})
+		case *dns.EDNS0_SUBNET:
+			option.SourceScope = option.SourceNetmask
+			m.IsEdns0().Option = append(m.IsEdns0().Option, option)
+		}
+	}
+	w.WriteMsg(m)
+}
+
+func TestEDNSTransport(t *testing.T) {
+	handler := &cookieHandler{}
+	addr, stop := startDNSServer(t, handler)
+	defer stop()
+	transport := NewEDNSTransport(NewDNSTransport("udp"))
+	transport.Cookies = true
+	transport.Subnet, _ = ParseClientSubnet("192.0.2.77")
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeNS)
+	for i := 0; i < 2; i++ {
+		in, _, err := transport.Exchange(m, addr)
+		if err != nil || in.Rcode != dns.RcodeSuccess {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Exchange", "NOERROR", in)
+		}
+	}
+	handler.mu.Lock()
+	queries := handler.queries
+	handler.mu.Unlock()
+	if len(queries) != 3 {
+		t.Fatalf(tests.ErrFmtExpectedGotV, "queries (one rejected for its cookie)", 3, len(queries))
+	}
+	if m.IsEdns0() != nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "query", "unchanged", m)
+	}
+	opt := queries[2].IsEdns0()
+	if opt == nil || opt.UDPSize() != DefaultEDNSSize {
+		t.Fatalf(tests.ErrFmtExpectedGot, "OPT", "UDP size 1232", opt)
+	}
+	var subnet *dns.EDNS0_SUBNET
+	for _, option := range opt.Option {
+		if s, ok := option.(*dns.EDNS0_SUBNET); ok {
+			subnet = s
+		}
+	}
+	if subnet == nil || subnet.SourceNetmask != 24 || !subnet.Address.Equal(net.ParseIP("192.0.2.0")) {
+		t.Errorf(tests.ErrFmtExpectedGot, "client subnet", "192.0.2.0/24", subnet)
+	}
+}
+
+func TestParseClientSubnet(t *testing.T) {
+	for subnet, expected := range map[string]string{
+		"192.0.2.77":       "192.0.2.0/24",
+		"198.51.100.0/22":  "198.51.100.0/22",
+		"2001:db8:1:2::9":  "2001:db8:1::/56",
+		"2001:db8::/32":    "2001:db8::/32",
+		"not an address":   "",
+		"192.0.2.1/33":     "",
+		"2001:db8::1/1000": "",
+	} {
+		ipnet, err := ParseClientSubnet(subnet)
+		switch {
+		case expected == "" && err == nil:
+			t.Errorf(tests.ErrFmtExpectedGot, subnet, "error", ipnet)
+		case expected != "" && (err != nil || ipnet.String() != expected):
+			t.Errorf(tests.ErrFmtExpectedGot, subnet, expected, ipnet)
+		}
+	}
+}
+
+// truncatingHandler sends truncated answers without records over UDP, and full answers over TCP
+func truncatingHandler(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		m.Truncated = true
+	} else {
+		m.Answer = append(m.Answer, mustRR(req.Question[0].Name+" 3600 IN NS ns.example.net."))
+	}
+	w.WriteMsg(m)
+}
+
+func TestTruncatedAnswersOverTCP(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(truncatingHandler))
+	defer stop()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("Can't listen on TCP at %s: %s", addr, err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{Listener: listener, Handler: dns.HandlerFunc(truncatingHandler),
+		NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	defer server.Shutdown()
+
+	pool := NewUDPPool()
+	defer pool.Close()
+	for _, transport := range []Transport{NewDNSTransport("udp"), NewUDPTransport(pool)} {
+		m := new(dns.Msg)
+		m.SetQuestion("example.com.", dns.TypeNS)
+		in, _, err := transport.Exchange(m, addr)
+		if err != nil || in.Truncated || len(in.Answer) != 1 {
+			t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "full answer over TCP", in)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package query
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for a ServerPool circuit breaker
+const (
+	DefaultMinSamples     = 10
+	DefaultMaxFailureRate = 0.5
+	DefaultCooldown       = 30 * time.Second
+
+	healthWindow = 100 // samples kept per server before older ones start to fade
+)
+
+// ServerStats is a snapshot of the health of a server in a ServerPool
+type ServerStats struct {
+	Server    string
+	Successes int
+	Failures  int
+	Latency   time.Duration // moving average of successful queries
+	Ejected   bool
+	Ejections int
+}
+
+// serverHealth tracks one server of a ServerPool
+type serverHealth struct {
+	ServerStats
+	total        ServerStats // counters since the pool was created, as Successes/Failures fade
+	ejectedUntil time.Time
+	probing      bool
+}
+
+// ServerPool picks DNS servers for queries, tracking their success rate and latency. Servers failing more than
+// MaxFailureRate of at least MinSamples queries are ejected, and get a single probe query after Cooldown to decide if
+// they come back.
+type ServerPool struct {
+	MinSamples     int
+	MaxFailureRate float64
+	Cooldown       time.Duration
+
+	mu      sync.Mutex
+	servers []*serverHealth
+	index   map[string]*serverHealth
+}
+
+// NewServerPool returns a ServerPool with the default circuit breaker settings
+func NewServerPool(servers []string) *ServerPool {
+	p := &ServerPool{
+		MinSamples:     DefaultMinSamples,
+		MaxFailureRate: DefaultMaxFailureRate,
+		Cooldown:       DefaultCooldown,
+		index:          map[string]*serverHealth{},
+	}
+	for _, server := range servers {
+		if _, ok := p.index[server]; ok {
+			continue
+		}
+		h := &serverHealth{ServerStats: ServerStats{Server: server}}
+		p.servers = append(p.servers, h)
+		p.index[server] = h
+	}
+	return p
+}
+
+// Len returns the number of servers in the pool, ejected or not
+func (p *ServerPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.servers)
+}
+
+// Servers returns every server in the pool, ejected or not
+func (p *ServerPool) Servers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var servers []string
+	for _, h := range p.servers {
+		servers = append(servers, h.Server)
+	}
+	return servers
+}
+
+// Remove drops server from the pool for good
+func (p *ServerPool) Remove(server string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.index[server]; !ok {
+		return
+	}
+	delete(p.index, server)
+	for i, h := range p.servers {
+		if h.Server == server {
+			p.servers = append(p.servers[:i], p.servers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Pick returns a server to query, avoiding servers in exclude when possible. Among two random healthy candidates the
+// one with the lower latency wins. When every server is ejected, the one closer to its probe is returned. An empty
+// pool returns "".
+func (p *ServerPool) Pick(exclude ...string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.servers) == 0 {
+		return ""
+	}
+	now := time.Now()
+	var candidates []*serverHealth
+	for _, h := range p.servers {
+		if !contains(exclude, h.Server) && p.usable(h, now) {
+			candidates = append(candidates, h)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, h := range p.servers {
+			if p.usable(h, now) {
+				candidates = append(candidates, h)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		next := p.servers[0]
+		for _, h := range p.servers[1:] {
+			if h.ejectedUntil.Before(next.ejectedUntil) {
+				next = h
+			}
+		}
+		return next.Server
+	}
+	chosen := candidates[rand.Intn(len(candidates))]
+	if other := candidates[rand.Intn(len(candidates))]; other.Latency < chosen.Latency {
+		chosen = other
+	}
+	if chosen.Ejected {
+		chosen.probing = true
+	}
+	return chosen.Server
+}
+
+// usable tells if h can take a query, letting a single probe through once an ejected server cooled down
+func (p *ServerPool) usable(h *serverHealth, now time.Time) bool {
+	if !h.Ejected {
+		return true
+	}
+	return !h.probing && now.After(h.ejectedUntil)
+}
+
+// Report records the outcome of a query to server
+func (p *ServerPool) Report(server string, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.index[server]
+	if !ok {
+		return
+	}
+	if failed {
+		h.Failures++
+		h.total.Failures++
+	} else {
+		h.Successes++
+		h.total.Successes++
+		if h.Latency == 0 {
+			h.Latency = latency
+		} else {
+			h.Latency = (h.Latency*7 + latency) / 8
+		}
+	}
+	if h.Ejected {
+		if h.probing {
+			h.probing = false
+			if failed {
+				h.ejectedUntil = time.Now().Add(p.Cooldown)
+			} else {
+				h.Ejected = false
+				h.Successes, h.Failures = 1, 0
+			}
+		}
+		return
+	}
+	samples := h.Successes + h.Failures
+	if samples >= p.MinSamples && float64(h.Failures)/float64(samples) > p.MaxFailureRate {
+		h.Ejected = true
+		h.Ejections++
+		h.ejectedUntil = time.Now().Add(p.Cooldown)
+		h.Successes, h.Failures = 0, 0
+		return
+	}
+	if samples >= healthWindow {
+		h.Successes /= 2
+		h.Failures /= 2
+	}
+}
+
+// Release gives back server, returned by Pick, when no query was made to it. Every Pick is followed by a Report or
+// a Release, so a probe of an ejected server that never happened lets another one through.
+func (p *ServerPool) Release(server string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.index[server]; ok {
+		h.probing = false
+	}
+}
+
+// Ejected returns the servers currently out of the pool
+func (p *ServerPool) Ejected() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ejected []string
+	for _, h := range p.servers {
+		if h.Ejected {
+			ejected = append(ejected, h.Server)
+		}
+	}
+	return ejected
+}
+
+// Stats returns the health of every server, sorted by server, with query counters since the pool was created
+func (p *ServerPool) Stats() []ServerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var stats []ServerStats
+	for _, h := range p.servers {
+		s := h.ServerStats
+		s.Successes, s.Failures = h.total.Successes, h.total.Failures
+		stats = append(stats, s)
+	}
+	sort.Sort(byServer(stats))
+	return stats
+}
+
+type byServer []ServerStats
+
+func (s byServer) Len() int           { return len(s) }
+func (s byServer) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byServer) Less(i, j int) bool { return s[i].Server < s[j].Server }
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func TestServerPoolPickExcludes(t *testing.T) {
+	p := NewServerPool([]string{"a", "b", "c"})
+	for i := 0; i < 20; i++ {
+		if server := p.Pick("a", "b"); server != "c" {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Pick", "c", server)
+		}
+	}
+	if server := p.Pick("a", "b", "c"); server == "" {
+		t.Errorf(tests.ErrFmtExpectedGot, "Pick", "any server", server)
+	}
+}
+
+func TestEmptyServerPool(t *testing.T) {
+	if server := NewServerPool(nil).Pick(); server != "" {
+		t.Errorf(tests.ErrFmtExpectedGot, "Pick", "", server)
+	}
+	r := NewNSChecker(nil, NewDNSTransport("udp")).Check(context.Background(), "example.com")
+	if r.Err != ErrNoServers || r.Attempts != 1 || r.Status != StatusError {
+		t.Errorf(tests.ErrFmtExpectedGotV, "NSChecker.Check", ErrNoServers, r.Err)
+	}
+}
+
+func TestServerPoolEjectsAndProbes(t *testing.T) {
+	p := NewServerPool([]string{"bad", "good"})
+	p.MinSamples = 4
+	p.Cooldown = 20 * time.Millisecond
+	for i := 0; i < 4; i++ {
+		p.Report("bad", 0, true)
+		p.Report("good", time.Millisecond, false)
+	}
+	if ejected := p.Ejected(); !reflect.DeepEqual(ejected, []string{"bad"}) {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Ejected", []string{"bad"}, ejected)
+	}
+	for i := 0; i < 20; i++ {
+		if server := p.Pick(); server != "good" {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Pick", "good", server)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if server := p.Pick("good"); server != "bad" {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Pick", "bad", server)
+	}
+	if server := p.Pick("good"); server != "good" {
+		t.Errorf(tests.ErrFmtExpectedGot, "Pick", "good (single probe)", server)
+	}
+	p.Report("bad", time.Millisecond, false)
+	if ejected := p.Ejected(); len(ejected) != 0 {
+		t.Errorf(tests.ErrFmtExpectedGot, "Ejected", []string{}, ejected)
+	}
+
+	stats := p.Stats()
+	if stats[0].Server != "bad" || stats[0].Failures != 4 || stats[0].Successes != 1 || stats[0].Ejections != 1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Stats", "bad with 4 failures, 1 success and 1 ejection", stats[0])
+	}
+}
+
+func TestServerPoolProbeReleasedWithoutQuery(t *testing.T) {
+	p := NewServerPool([]string{"bad", "good"})
+	p.MinSamples, p.Cooldown = 1, time.Millisecond
+	p.Report("bad", 0, true)
+	time.Sleep(5 * time.Millisecond)
+	c := NewNSChecker(nil, NewDNSTransport("udp"))
+	c.Servers = p
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	probe := func() string {
+		server := p.Pick("good")
+		p.Release(server)
+		return server
+	}
+	for i := 0; i < 20; i++ {
+		if r := c.checkWith(ctx, "example.com", p.Pick("good")); r.Err != context.Canceled {
+			t.Fatalf(tests.ErrFmtExpectedGotV, "checkWith", context.Canceled, r.Err)
+		}
+		if server := probe(); server != "bad" {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Pick after checkWith", "bad", server)
+		}
+		c.hasWildcard(ctx, "com", 1)
+		if server := probe(); server != "bad" {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Pick after hasWildcard", "bad", server)
+		}
+	}
+}
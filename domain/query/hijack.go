@@ -0,0 +1,109 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultProbeTLDs are the TLDs used to look for NXDOMAIN hijacking
+var DefaultProbeTLDs = []string{"com", "net", "org"}
+
+const labelChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// randomLabel returns a label that is very unlikely to be registered anywhere
+func randomLabel() string {
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = labelChars[rand.Intn(len(labelChars))]
+	}
+	return "x" + string(b)
+}
+
+// probeTally counts the answers of a server for random names under a TLD
+type probeTally struct {
+	nxdomain int
+	positive int
+	example  string // first name answered positively
+}
+
+// FindHijackers asks each server about random nonsense names under tlds, with both A and NS queries, and returns the
+// servers that answered positively for names the majority of servers say don't exist, with the reason. TLDs that
+// most servers answer positively (wildcards) are not considered.
+func (c *NSChecker) FindHijackers(ctx context.Context, tlds []string, probes int) map[string]string {
+	servers := c.Servers.Servers()
+	tallies := make([]map[string]*probeTally, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			tallies[i] = c.probeServer(ctx, server, tlds, probes)
+		}(i, server)
+	}
+	wg.Wait()
+
+	hijackers := map[string]string{}
+	for _, tld := range tlds {
+		nxdomain, answered := 0, 0
+		for i := range servers {
+			tally := tallies[i][tld]
+			if tally.nxdomain+tally.positive == 0 {
+				continue
+			}
+			answered++
+			if tally.positive == 0 {
+				nxdomain++
+			}
+		}
+		if answered >= 3 && nxdomain*2 <= answered {
+			continue
+		}
+		for i, server := range servers {
+			if tally := tallies[i][tld]; tally.positive > 0 {
+				if _, ok := hijackers[server]; !ok {
+					hijackers[server] = fmt.Sprintf("answered %d of %d probes for non-existent names (%s)",
+						tally.positive, tally.positive+tally.nxdomain, tally.example)
+				}
+			}
+		}
+	}
+	return hijackers
+}
+
+// probeServer tallies the answers of server for probes random names under each TLD. Every TLD gets a tally, even when
+// ctx is done before it is probed.
+func (c *NSChecker) probeServer(ctx context.Context, server string, tlds []string, probes int) map[string]*probeTally {
+	tallies := map[string]*probeTally{}
+	for _, tld := range tlds {
+		tallies[tld] = &probeTally{}
+	}
+	for _, tld := range tlds {
+		tally := tallies[tld]
+		for i := 0; i < probes; i++ {
+			name := randomLabel() + "." + tld
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeNS} {
+				if err := c.Limits.Wait(ctx, server); err != nil {
+					return tallies
+				}
+				in, _, err := c.query(name, qtype, server)
+				if err != nil {
+					continue
+				}
+				switch {
+				case in.Rcode == dns.RcodeNameError:
+					tally.nxdomain++
+				case in.Rcode == dns.RcodeSuccess && len(in.Answer) > 0:
+					tally.positive++
+					if tally.example == "" {
+						tally.example = fmt.Sprintf("%s %s", name, dns.TypeToString[qtype])
+					}
+				}
+			}
+		}
+	}
+	return tallies
+}
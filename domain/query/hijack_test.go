@@ -0,0 +1,65 @@
+package query
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// probeHandler answers NXDOMAIN for everything, except names under the wildcard TLD, or anything when hijacking
+func probeHandler(hijack bool, wildcard string) dns.Handler {
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		if hijack || strings.HasSuffix(q.Name, "."+wildcard+".") {
+			m.Answer = append(m.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+}
+
+func TestFindHijackers(t *testing.T) {
+	var servers []string
+	for i := 0; i < 4; i++ {
+		addr, stop := startDNSServer(t, probeHandler(i == 3, "wild"))
+		defer stop()
+		servers = append(servers, addr)
+	}
+	c := NewNSChecker(servers, NewDNSTransport("udp"))
+
+	hijackers := c.FindHijackers(context.Background(), []string{"com", "wild"}, 2)
+	if len(hijackers) != 1 || hijackers[servers[3]] == "" {
+		t.Errorf(tests.ErrFmtExpectedGotV, "FindHijackers", servers[3], hijackers)
+	}
+
+	hijackers = c.FindHijackers(context.Background(), []string{"wild"}, 2)
+	if len(hijackers) != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "FindHijackers", "no hijackers for a wildcard TLD", hijackers)
+	}
+
+	c.Servers.Remove(servers[3])
+	if got := c.Servers.Servers(); len(got) != 3 || contains(got, servers[3]) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Remove", servers[:3], got)
+	}
+}
+
+func TestFindHijackersCancelled(t *testing.T) {
+	addr, stop := startDNSServer(t, probeHandler(true, ""))
+	defer stop()
+	c := NewNSChecker([]string{addr}, NewDNSTransport("udp"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if hijackers := c.FindHijackers(ctx, []string{"com", "net"}, 2); len(hijackers) != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "FindHijackers", "no hijackers when cancelled", hijackers)
+	}
+}
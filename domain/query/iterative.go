@@ -0,0 +1,326 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RootHints are the root servers, as published by IANA at https://www.internic.net/domain/named.root
+const RootHints = `
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+B.ROOT-SERVERS.NET.      3600000      AAAA  2801:1b8:10::b
+.                        3600000      NS    C.ROOT-SERVERS.NET.
+C.ROOT-SERVERS.NET.      3600000      A     192.33.4.12
+C.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:2::c
+.                        3600000      NS    D.ROOT-SERVERS.NET.
+D.ROOT-SERVERS.NET.      3600000      A     199.7.91.13
+D.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:2d::d
+.                        3600000      NS    E.ROOT-SERVERS.NET.
+E.ROOT-SERVERS.NET.      3600000      A     192.203.230.10
+E.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:a8::e
+.                        3600000      NS    F.ROOT-SERVERS.NET.
+F.ROOT-SERVERS.NET.      3600000      A     192.5.5.241
+F.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:2f::f
+.                        3600000      NS    G.ROOT-SERVERS.NET.
+G.ROOT-SERVERS.NET.      3600000      A     192.112.36.4
+G.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:12::d0d
+.                        3600000      NS    H.ROOT-SERVERS.NET.
+H.ROOT-SERVERS.NET.      3600000      A     198.97.190.53
+H.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:1::53
+.                        3600000      NS    I.ROOT-SERVERS.NET.
+I.ROOT-SERVERS.NET.      3600000      A     192.36.148.17
+I.ROOT-SERVERS.NET.      3600000      AAAA  2001:7fe::53
+.                        3600000      NS    J.ROOT-SERVERS.NET.
+J.ROOT-SERVERS.NET.      3600000      A     192.58.128.30
+J.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:c27::2:30
+.                        3600000      NS    K.ROOT-SERVERS.NET.
+K.ROOT-SERVERS.NET.      3600000      A     193.0.14.129
+K.ROOT-SERVERS.NET.      3600000      AAAA  2001:7fd::1
+.                        3600000      NS    L.ROOT-SERVERS.NET.
+L.ROOT-SERVERS.NET.      3600000      A     199.7.83.42
+L.ROOT-SERVERS.NET.      3600000      AAAA  2001:500:9f::42
+.                        3600000      NS    M.ROOT-SERVERS.NET.
+M.ROOT-SERVERS.NET.      3600000      A     202.12.27.33
+M.ROOT-SERVERS.NET.      3600000      AAAA  2001:dc3::35
+`
+
+// maxReferralDepth limits how many delegations can be followed to find the servers of a zone, nameserver addresses
+// included
+const maxReferralDepth = 10
+
+// ParseRootHints returns the addresses of the root servers in a root hints file (named.root), IPv6 ones included
+// only if ipv6 is true
+func ParseRootHints(content string, ipv6 bool) ([]string, error) {
+	var nameservers []string
+	addrs := map[string][]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid root hint %q: %s", line, err)
+		}
+		owner := strings.ToLower(rr.Header().Name)
+		switch rr := rr.(type) {
+		case *dns.NS:
+			if owner == "." {
+				nameservers = append(nameservers, strings.ToLower(rr.Ns))
+			}
+		case *dns.A:
+			addrs[owner] = append(addrs[owner], rr.A.String())
+		case *dns.AAAA:
+			if ipv6 {
+				addrs[owner] = append(addrs[owner], rr.AAAA.String())
+			}
+		}
+	}
+	var roots []string
+	for _, ns := range nameservers {
+		roots = append(roots, addrs[ns]...)
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("No root server addresses found in root hints")
+	}
+	return roots, nil
+}
+
+// LoadRootHints reads the addresses of the root servers from a root hints file (named.root)
+func LoadRootHints(path string, ipv6 bool) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRootHints(string(content), ipv6)
+}
+
+// IterativeChecker is a Checker that asks the authoritative servers of the parent zone of each domain directly, with
+// recursion disabled, instead of going through recursive resolvers. The servers of each zone are found by following
+// referrals from the Roots once, and cached for the whole run. Answers are classified by Rcodes, and failed queries are
+// retried on other servers of the zone according to Retry.
+type IterativeChecker struct {
+	Roots     []string // addresses of the root servers
+	Port      string   // of every server without one
+	IPv6      bool     // also use IPv6 addresses of nameservers
+	Transport Transport
+	Limits    *RateLimiter
+	Retry     RetryPolicy
+	Rcodes    RcodePolicy
+
+	mu    sync.Mutex
+	zones map[string][]string
+}
+
+// NewIterativeChecker returns an IterativeChecker starting from roots, and talking to them through transport
+func NewIterativeChecker(roots []string, transport Transport) *IterativeChecker {
+	return &IterativeChecker{
+		Roots:     roots,
+		Port:      "53",
+		Transport: transport,
+		Retry:     DefaultRetryPolicy,
+		Rcodes:    DefaultRcodePolicy,
+		zones:     map[string][]string{},
+	}
+}
+
+// Check implements Checker
+func (c *IterativeChecker) Check(ctx context.Context, domain string) Result {
+	var tried []string
+	return c.Retry.Do(ctx, func(attempt int) Result {
+		servers, err := c.Servers(ctx, parentZone(dns.Fqdn(domain)))
+		if err != nil {
+			return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: err}
+		}
+		server := pickAddr(servers, tried)
+		tried = append(tried, server)
+		in, rtt, err := c.ask(ctx, domain, dns.TypeNS, server)
+		r := Result{Domain: domain, Rcode: dns.RcodeRefused, Err: err, Server: server, RTT: rtt}
+		r.Transport = c.Transport.Proto(server)
+		if err == nil {
+			r.Rcode = in.Rcode
+			r.NS, r.SOA = records(in)
+			r.negativeTTL = negativeTTL(in)
+			r = c.Rcodes.apply(r)
+			r.Status = undelegated(r)
+		}
+		return r
+	})
+}
+
+// Servers returns the addresses of the authoritative servers of zone, following referrals from the root servers
+// the first time
+func (c *IterativeChecker) Servers(ctx context.Context, zone string) ([]string, error) {
+	return c.servers(ctx, dns.Fqdn(zone), 0)
+}
+
+func (c *IterativeChecker) servers(ctx context.Context, zone string, depth int) ([]string, error) {
+	if zone == "." {
+		var roots []string
+		for _, root := range c.Roots {
+			roots = append(roots, serverAddr(root, c.Port))
+		}
+		return roots, nil
+	}
+	c.mu.Lock()
+	servers, ok := c.zones[zone]
+	c.mu.Unlock()
+	if ok {
+		return servers, nil
+	}
+	if depth > maxReferralDepth {
+		return nil, fmt.Errorf("Too many referrals looking for the servers of %s", zone)
+	}
+	servers, err := c.delegation(ctx, zone, depth)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.zones[zone] = servers
+	c.mu.Unlock()
+	return servers, nil
+}
+
+// delegation asks the servers of the parent of zone for its NS records and addresses. Zones without their own
+// servers (no zone cut) are served by the ones of their parent.
+func (c *IterativeChecker) delegation(ctx context.Context, zone string, depth int) ([]string, error) {
+	parentServers, err := c.servers(ctx, parentZone(zone), depth+1)
+	if err != nil {
+		return nil, err
+	}
+	in, err := c.askAny(ctx, zone, dns.TypeNS, parentServers)
+	if err != nil {
+		return nil, err
+	}
+	if in.Rcode == dns.RcodeNameError {
+		return nil, fmt.Errorf("Zone %s does not exist", zone)
+	}
+	var nameservers []string
+	for _, section := range [][]dns.RR{in.Answer, in.Ns} {
+		for _, rr := range section {
+			if ns, ok := rr.(*dns.NS); ok && equalNames(ns.Hdr.Name, zone) {
+				nameservers = append(nameservers, strings.ToLower(ns.Ns))
+			}
+		}
+	}
+	if len(nameservers) == 0 {
+		return parentServers, nil
+	}
+
+	glue := c.addrs(in.Extra, nameservers)
+	if len(glue) > 0 {
+		return glue, nil
+	}
+	var servers []string
+	for _, ns := range nameservers {
+		addrs, err := c.lookupHost(ctx, ns, depth+1)
+		if err == nil {
+			servers = append(servers, addrs...)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("No addresses found for the nameservers of %s", zone)
+	}
+	return servers, nil
+}
+
+// lookupHost returns the addresses of a nameserver without glue, asking the servers of its zone
+func (c *IterativeChecker) lookupHost(ctx context.Context, host string, depth int) ([]string, error) {
+	servers, err := c.servers(ctx, parentZone(host), depth)
+	if err != nil {
+		return nil, err
+	}
+	qtypes := []uint16{dns.TypeA}
+	if c.IPv6 {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+	var addrs []string
+	for _, qtype := range qtypes {
+		in, err := c.askAny(ctx, host, qtype, servers)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, c.addrs(in.Answer, []string{host})...)
+	}
+	return addrs, nil
+}
+
+// addrs returns the addresses of nameservers in rrs
+func (c *IterativeChecker) addrs(rrs []dns.RR, nameservers []string) []string {
+	var addrs []string
+	for _, rr := range rrs {
+		if !contains(nameservers, strings.ToLower(rr.Header().Name)) {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs = append(addrs, net.JoinHostPort(rr.A.String(), c.Port))
+		case *dns.AAAA:
+			if c.IPv6 {
+				addrs = append(addrs, net.JoinHostPort(rr.AAAA.String(), c.Port))
+			}
+		}
+	}
+	return addrs
+}
+
+// askAny asks servers about name until one of them gives a NOERROR or NXDOMAIN answer, up to Retry.MaxAttempts
+func (c *IterativeChecker) askAny(ctx context.Context, name string, qtype uint16, servers []string) (*dns.Msg, error) {
+	var tried []string
+	var in *dns.Msg
+	var err error
+	for attempt := 0; attempt < c.Retry.MaxAttempts || attempt == 0; attempt++ {
+		server := pickAddr(servers, tried)
+		tried = append(tried, server)
+		in, _, err = c.ask(ctx, name, qtype, server)
+		if err == nil && (in.Rcode == dns.RcodeSuccess || in.Rcode == dns.RcodeNameError) {
+			return in, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("%s answer for %s", dns.RcodeToString[in.Rcode], name)
+	}
+	return nil, err
+}
+
+// ask sends a non-recursive query about name to server
+func (c *IterativeChecker) ask(ctx context.Context, name string, qtype uint16,
+	server string) (*dns.Msg, time.Duration, error) {
+	if err := c.Limits.Wait(ctx, server); err != nil {
+		return nil, 0, err
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = false
+	return c.Transport.Exchange(m, server)
+}
+
+// pickAddr returns a random address, avoiding the ones in tried when possible
+func pickAddr(addrs, tried []string) string {
+	var fresh []string
+	for _, addr := range addrs {
+		if !contains(tried, addr) {
+			fresh = append(fresh, addr)
+		}
+	}
+	if len(fresh) == 0 {
+		fresh = addrs
+	}
+	return fresh[rand.Intn(len(fresh))]
+}
@@ -0,0 +1,101 @@
+package query
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// authoritativeServers plays the root and the test. and other. zones, all on the same address. Nameservers of other.
+// are in test. and have no glue. Only taken.test. and taken.other. are delegated.
+type authoritativeServers struct {
+	mu        sync.Mutex
+	queries   map[string]int
+	recursion bool
+}
+
+func (s *authoritativeServers) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	s.mu.Lock()
+	s.queries[q.Name]++
+	s.recursion = s.recursion || req.RecursionDesired
+	s.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	switch {
+	case q.Name == "test.":
+		m.Ns = append(m.Ns, mustRR("test. 3600 IN NS ns1.test."))
+		m.Extra = append(m.Extra, mustRR("ns1.test. 3600 IN A 127.0.0.1"))
+	case q.Name == "other.":
+		m.Ns = append(m.Ns, mustRR("other. 3600 IN NS ns.test."))
+	case q.Name == "ns.test." && q.Qtype == dns.TypeA:
+		m.Authoritative = true
+		m.Answer = append(m.Answer, mustRR("ns.test. 3600 IN A 127.0.0.1"))
+	case strings.HasPrefix(q.Name, "taken."):
+		m.Ns = append(m.Ns, mustRR(q.Name+" 3600 IN NS ns."+q.Name))
+	case strings.HasSuffix(q.Name, ".test.") || strings.HasSuffix(q.Name, ".other."):
+		m.Authoritative = true
+		m.Rcode = dns.RcodeNameError
+		zone := q.Name[strings.Index(q.Name, ".")+1:]
+		m.Ns = append(m.Ns, mustRR(zone+" 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+	default:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, mustRR(". 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+	}
+	w.WriteMsg(m)
+}
+
+func TestIterativeChecker(t *testing.T) {
+	servers := &authoritativeServers{queries: map[string]int{}}
+	addr, stop := startDNSServer(t, servers)
+	defer stop()
+	host, port, _ := net.SplitHostPort(addr)
+	c := NewIterativeChecker([]string{host}, NewDNSTransport("udp"))
+	c.Port = port
+	c.Retry = RetryPolicy{MaxAttempts: 1}
+
+	for domain, available := range map[string]bool{
+		"taken.test": false, "free.test": true, "taken.other": false, "free.other": true, "other.test": true,
+	} {
+		if r := c.Check(context.Background(), domain); r.Available() != available || r.Unknown() {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Check", domain, r.String(false))
+		}
+	}
+	if r := c.Check(context.Background(), "free.nope"); !r.Unknown() {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "unknown for a TLD that does not exist", r.String(false))
+	}
+
+	servers.mu.Lock()
+	defer servers.mu.Unlock()
+	if servers.recursion {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "no recursion desired", "RD bit set")
+	}
+	for _, zone := range []string{"test.", "other.", "ns.test."} {
+		if servers.queries[zone] != 1 {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Check", zone+" asked once", servers.queries[zone])
+		}
+	}
+	if servers, _ := c.Servers(context.Background(), "other"); !reflect.DeepEqual(servers, []string{addr}) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Servers", []string{addr}, servers)
+	}
+}
+
+func TestParseRootHints(t *testing.T) {
+	roots, err := ParseRootHints(RootHints, false)
+	if err != nil || len(roots) != 13 || roots[0] != "198.41.0.4" {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseRootHints", "13 IPv4 root servers", roots)
+	}
+	if roots, _ = ParseRootHints(RootHints, true); len(roots) != 26 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseRootHints", 26, len(roots))
+	}
+	if _, err := ParseRootHints("; nothing here\n", false); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ParseRootHints", "Error", "No Error")
+	}
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoServers is reported by a NSChecker without any DNS server to ask
+var ErrNoServers = errors.New("No DNS servers to ask")
+
+// NSChecker is a Checker that queries a DNS server for the NS records of a domain. Answers are classified by Rcodes,
+// and failed queries are retried on a different server according to Retry. Queries are paced by Limits, if set.
+// With DNSSEC set, NXDOMAIN answers are validated and proven ones are flagged as such. Domains under Wildcards
+// suffixes are checked by the owner of their SOA record instead, see FindWildcards. With Probe set, NS answers are
+// classified into the Presence of each domain, telling parking services among ParkingNS, see probe.
+type NSChecker struct {
+	Servers   *ServerPool
+	Limits    *RateLimiter
+	Transport Transport
+	Retry     RetryPolicy
+	Rcodes    RcodePolicy
+	DNSSEC    *DNSSECValidator
+	Wildcards map[string]bool
+	Probe     bool
+	ParkingNS []string
+}
+
+// NewNSChecker returns a NSChecker talking to dnsServers through transport
+func NewNSChecker(dnsServers []string, transport Transport) *NSChecker {
+	return &NSChecker{
+		Servers:   NewServerPool(dnsServers),
+		Transport: transport,
+		Retry:     DefaultRetryPolicy,
+		Rcodes:    DefaultRcodePolicy,
+		ParkingNS: DefaultParkingNS,
+	}
+}
+
+// Check implements Checker
+func (c *NSChecker) Check(ctx context.Context, domain string) Result {
+	var tried []string
+	return c.Retry.Do(ctx, func(attempt int) Result {
+		dnsServer := c.Servers.Pick(tried...)
+		tried = append(tried, dnsServer)
+		return c.checkWith(ctx, domain, dnsServer)
+	})
+}
+
+// checkWith makes a single attempt at checking domain with dnsServer
+func (c *NSChecker) checkWith(ctx context.Context, domain, dnsServer string) Result {
+	if dnsServer == "" {
+		return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: ErrNoServers}
+	}
+	if err := c.Limits.Wait(ctx, dnsServer); err != nil {
+		c.Servers.Release(dnsServer)
+		return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: err}
+	}
+	qtype, wildcard := dns.TypeNS, c.wildcardSuffix(domain)
+	if wildcard {
+		qtype = dns.TypeSOA
+	}
+	in, rtt, err := c.query(domain, qtype, dnsServer)
+	r := Result{Domain: domain, Rcode: dns.RcodeRefused, Err: err, Server: dnsServer, RTT: rtt}
+	r.Transport = c.Transport.Proto(dnsServer)
+	if err == nil {
+		r.Rcode = in.Rcode
+		r.NS, r.SOA = records(in)
+		if wildcard {
+			r.Rcode = soaRcode(domain, in)
+		}
+		r.negativeTTL = negativeTTL(in)
+		r = c.Rcodes.apply(r)
+		if !wildcard {
+			r.Status = undelegated(r)
+		}
+	}
+	if r.Err == nil && c.DNSSEC != nil && in.Rcode == dns.RcodeNameError {
+		r.Proven, r.Err = c.DNSSEC.ValidateNXDOMAIN(domain, in, c.exchangeWith(dnsServer))
+	}
+	if r.Err == nil && c.Probe && !wildcard {
+		r = c.probe(ctx, r, dnsServer)
+	}
+	c.Servers.Report(dnsServer, rtt, retriable(r.Err))
+	return r
+}
+
+// exchangeWith returns an exchangeFunc querying dnsServer
+func (c *NSChecker) exchangeWith(dnsServer string) exchangeFunc {
+	return func(name string, qtype uint16) (*dns.Msg, error) {
+		in, _, err := c.query(name, qtype, dnsServer)
+		return in, err
+	}
+}
+
+// undelegated tells registered domains apart from names that exist in the registry zone without being delegated,
+// like nic.TLD. Those answer NS queries with NOERROR and no NS records (NODATA).
+func undelegated(r Result) Status {
+	if r.Status == StatusRegistered && r.Rcode == dns.RcodeSuccess && len(r.NS) == 0 {
+		return StatusReserved
+	}
+	return r.Status
+}
+
+// records returns the NS and SOA records of an answer
+func records(in *dns.Msg) (nameservers []*dns.NS, soa *dns.SOA) {
+	for _, section := range [][]dns.RR{in.Answer, in.Ns} {
+		for _, rr := range section {
+			switch rr := rr.(type) {
+			case *dns.NS:
+				nameservers = append(nameservers, rr)
+			case *dns.SOA:
+				soa = rr
+			}
+		}
+	}
+	return nameservers, soa
+}
+
+// negativeTTL returns how long a negative answer can be cached (RFC 2308), or zero if it has no SOA record
+func negativeTTL(in *dns.Msg) time.Duration {
+	for _, rr := range in.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := soa.Minttl
+			if soa.Hdr.Ttl < ttl {
+				ttl = soa.Hdr.Ttl
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return 0
+}
+
+// Asks dnsServer for the qtype records of name, with the DO bit set when validating DNSSEC
+func (c *NSChecker) query(name string, qtype uint16, dnsServer string) (*dns.Msg, time.Duration, error) {
+	m := new(dns.Msg)
+	m.RecursionDesired = true
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	if c.DNSSEC != nil {
+		m.SetEdns0(4096, true)
+	}
+	return c.Transport.Exchange(m, dnsServer)
+}
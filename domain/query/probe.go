@@ -0,0 +1,103 @@
+package query
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Presence is what a composite DNS probe found out about a domain
+type Presence int
+
+// Possible Presence of a domain in DNS
+const (
+	PresenceUnknown     Presence = iota // not probed
+	PresenceAbsent                      // not in the parent zone
+	PresenceUndelegated                 // exists in the parent zone, but has no nameservers of its own
+	PresenceDelegated                   // has nameservers
+	PresenceParked                      // has the nameservers of a parking service
+)
+
+var presenceNames = map[Presence]string{
+	PresenceUnknown:     "",
+	PresenceAbsent:      "ABSENT",
+	PresenceUndelegated: "UNDELEGATED",
+	PresenceDelegated:   "DELEGATED",
+	PresenceParked:      "PARKED",
+}
+
+// String returns the name of the Presence, or an empty string when unknown
+func (p Presence) String() string {
+	return presenceNames[p]
+}
+
+// parsePresence returns the Presence called name, or PresenceUnknown
+func parsePresence(name string) Presence {
+	for p, n := range presenceNames {
+		if n == name {
+			return p
+		}
+	}
+	return PresenceUnknown
+}
+
+// DefaultParkingNS are the domains of nameservers used by parking services
+var DefaultParkingNS = []string{
+	"above.com", "afternic.com", "bodis.com", "dan.com", "parkingcrew.net", "parklogic.com", "sedoparking.com",
+}
+
+// probe finds the Presence of the domain in r from its NS answer, which tells names delegated (to a parking service
+// or not) apart from names that exist in the parent zone without nameservers (NODATA), and from absent ones
+// (NXDOMAIN). NODATA is checked against a random name next to the domain, so a wildcard in the parent zone that
+// FindWildcards was not asked about is not taken for undelegated names: under one, the domain is absent unless it
+// is delegated. A registered domain left without nameservers is not in the parent zone at all, and can't be told
+// apart from an available one by DNS.
+func (c *NSChecker) probe(ctx context.Context, r Result, dnsServer string) Result {
+	switch {
+	case r.Status == StatusRegistered && len(r.NS) > 0:
+		r.Presence = PresenceDelegated
+		if parked(r.NS, c.ParkingNS) {
+			r.Presence = PresenceParked
+		}
+	case r.Rcode == dns.RcodeNameError:
+		r.Presence = PresenceAbsent
+	case r.Rcode == dns.RcodeSuccess:
+		parts := strings.SplitN(r.Domain, ".", 2)
+		if len(parts) < 2 {
+			r.Presence = PresenceUndelegated
+			return r
+		}
+		if err := c.Limits.Wait(ctx, dnsServer); err != nil {
+			r.Err = err
+			return r
+		}
+		in, _, err := c.query(randomLabel()+"."+parts[1], dns.TypeNS, dnsServer)
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		switch in.Rcode {
+		case dns.RcodeNameError:
+			r.Presence = PresenceUndelegated
+		case dns.RcodeSuccess:
+			r.Rcode = dns.RcodeNameError
+			r = c.Rcodes.apply(r)
+			r.Presence = PresenceAbsent
+		}
+	}
+	return r
+}
+
+// parked tells if any of nameservers belongs to one of the parking domains
+func parked(nameservers []*dns.NS, parking []string) bool {
+	for _, ns := range nameservers {
+		host := strings.ToLower(strings.TrimSuffix(ns.Ns, "."))
+		for _, domain := range parking {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
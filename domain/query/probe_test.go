@@ -0,0 +1,98 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// probeZone is a "test" zone with a delegated domain, a parked one, a registry name with an address but no
+// nameservers, an empty non-terminal above a delegation, and a wildcard with a delegation next to it
+var probeZone = map[string][]string{
+	"taken.test.":      {"taken.test. 3600 IN NS ns.taken.test."},
+	"parked.test.":     {"parked.test. 3600 IN NS ns1.sedoparking.com."},
+	"nic.test.":        {"nic.test. 3600 IN A 192.0.2.1"},
+	"sub.held.test.":   {"sub.held.test. 3600 IN NS ns.sub.held.test."},
+	"*.wild.test.":     {"*.wild.test. 3600 IN A 192.0.2.2"},
+	"taken.wild.test.": {"taken.wild.test. 3600 IN NS ns.taken.wild.test."},
+}
+
+// probeZoneHandler answers from probeZone as a consistent server would: NXDOMAIN for every type of a name not in
+// the zone, and NODATA for the other types of one that is
+func probeZoneHandler(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	records, exists := probeZone[q.Name]
+	for name := range probeZone {
+		exists = exists || strings.HasSuffix(name, "."+q.Name)
+	}
+	if labels := strings.SplitN(q.Name, ".", 2); !exists && len(labels) == 2 {
+		for _, record := range probeZone["*."+labels[1]] {
+			records, exists = append(records, q.Name+strings.TrimPrefix(record, "*."+labels[1])), true
+		}
+	}
+	for _, record := range records {
+		if rr := mustRR(record); rr.Header().Rrtype == q.Qtype {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	if !exists {
+		m.Rcode = dns.RcodeNameError
+	}
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, mustRR("test. 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+	}
+	w.WriteMsg(m)
+}
+
+func TestNSCheckerProbe(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(probeZoneHandler))
+	defer stop()
+	c := NewNSChecker([]string{addr}, NewDNSTransport("udp"))
+
+	// without probing, any name under an unknown wildcard looks reserved
+	if r := c.Check(context.Background(), "free.wild.test"); r.Status != StatusReserved || r.Presence != PresenceUnknown {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "free.wild.test reserved without Probe", r.String(false))
+	}
+
+	c.Probe = true
+	cases := []struct {
+		domain   string
+		status   Status
+		presence Presence
+	}{
+		{"taken.test", StatusRegistered, PresenceDelegated},
+		{"parked.test", StatusRegistered, PresenceParked},
+		{"nic.test", StatusReserved, PresenceUndelegated},
+		{"held.test", StatusReserved, PresenceUndelegated},
+		{"free.test", StatusAvailable, PresenceAbsent},
+		{"taken.wild.test", StatusRegistered, PresenceDelegated},
+		{"free.wild.test", StatusAvailable, PresenceAbsent},
+	}
+	for _, tc := range cases {
+		r := c.Check(context.Background(), tc.domain)
+		if r.Err != nil || r.Status != tc.status || r.Presence != tc.presence {
+			t.Errorf(tests.ErrFmtExpectedGot, "Check", tc.status.String()+" "+tc.presence.String(), r.String(false))
+		}
+	}
+}
+
+func TestParked(t *testing.T) {
+	ns := func(target string) []*dns.NS {
+		return []*dns.NS{mustRR("example.com. 3600 IN NS " + target).(*dns.NS)}
+	}
+	for target, expected := range map[string]bool{
+		"ns1.sedoparking.com.": true,
+		"NS2.BODIS.COM.":       true,
+		"ns1.notbodis.com.":    false,
+		"ns1.example.com.":     false,
+	} {
+		if got := parked(ns(target), DefaultParkingNS); got != expected {
+			t.Errorf(tests.ErrFmtExpectedGotV, target, expected, got)
+		}
+	}
+}
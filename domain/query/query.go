@@ -1,63 +1,162 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
-// Result represent a DNS query result
+// ErrUnsupported is reported by a Checker that cannot handle a domain, usually because its TLD is not served by
+// that backend
+var ErrUnsupported = errors.New("Domain not supported by checker")
+
+// Result represent a DNS query result, and how it was reached
 type Result struct {
-	Domain string
-	Rcode  int
-	err    error
+	Domain         string
+	Status         Status
+	Rcode          int
+	Err            error    // why the domain could not be checked, if it couldn't
+	RegistryStatus []string // Status values reported by the registry, like "redemption period" or "server hold"
+	Cached         bool     // true if the Result came from a Cache instead of a Checker
+	Proven         bool     // true if DNSSEC proved the domain does not exist
+	Answers        []Answer // what each DNS server said, when checked by a QuorumChecker
+	Presence       Presence // what a composite DNS probe found, if one was made
+
+	Server    string        // DNS server, RDAP service or WHOIS server that gave the last answer
+	Transport string        // how Server was asked: udp, tcp, tls, https, rdap or whois
+	RTT       time.Duration // round-trip time of the last answer
+	Attempts  int           // queries made, retries included
+	Started   time.Time
+	Finished  time.Time
+	NS        []*dns.NS // NS records of the last DNS answer
+	SOA       *dns.SOA  // SOA record of the last DNS answer, of the parent zone for NXDOMAIN answers
+
+	negativeTTL time.Duration // how long a NXDOMAIN answer can be cached, from the SOA record of the zone
 }
 
-// Format Result into string for output file
+// Format Result into string for output file. Unknown, disputed and DNSSEC proven results are marked as such even in
+// simple mode. Disputed results also carry the answer of each server. Otherwise only the full format tells how the
+// Result was reached: the rcode, error, registry status, server, transport, round-trip time, attempts and records.
 func (dr Result) String(simple bool) string {
+	answers := make([]string, len(dr.Answers))
+	for i, answer := range dr.Answers {
+		answers[i] = answer.String()
+	}
 	if simple {
+		if dr.Disputed() {
+			return fmt.Sprintf("%s\tDISPUTED\t%s\n", dr.Domain, strings.Join(answers, ","))
+		}
+		if dr.Unknown() {
+			return fmt.Sprintf("%s\t%s\n", dr.Domain, dr.Status)
+		}
+		if dr.Proven {
+			return fmt.Sprintf("%s\tPROVEN\n", dr.Domain)
+		}
 		return fmt.Sprintf("%s\n", dr.Domain)
 	}
-	return fmt.Sprintf("%s\t%s\t%q\t\n", dr.Domain, dns.RcodeToString[dr.Rcode], dr.err)
+	status := dr.Status.String()
+	if dr.Disputed() {
+		status = "DISPUTED"
+	} else if dr.Proven {
+		status += " PROVEN"
+	}
+	if dr.Presence != PresenceUnknown {
+		status += " " + dr.Presence.String()
+	}
+	errMsg := ""
+	if dr.Err != nil {
+		errMsg = dr.Err.Error()
+	}
+	out := fmt.Sprintf("%s\t%s\t%s\t%q\t%s\t%s\t%s\t%s\t%d\t%s", dr.Domain, status, dns.RcodeToString[dr.Rcode],
+		errMsg, strings.Join(dr.RegistryStatus, ","), dr.Server, dr.Transport, dr.RTT, dr.Attempts, dr.records())
+	if len(answers) > 0 {
+		out += "\t" + strings.Join(answers, ",")
+	}
+	return out + "\n"
+}
+
+// records returns the nameservers of the domain, or the zone of the SOA record when there are none
+func (dr Result) records() string {
+	if len(dr.NS) > 0 {
+		nameservers := make([]string, len(dr.NS))
+		for i, ns := range dr.NS {
+			nameservers[i] = ns.Ns
+		}
+		return strings.Join(nameservers, ",")
+	}
+	if dr.SOA != nil {
+		return "SOA " + dr.SOA.Hdr.Name
+	}
+	return ""
 }
 
-// Available return true if the domain is available (DNS NXDOMAIN)
+// Available return true if the checker found the domain available (usually a DNS NXDOMAIN)
 func (dr Result) Available() bool {
-	return dr.Rcode == dns.RcodeNameError
+	return dr.Status == StatusAvailable
+}
+
+// Unknown return true if the domain could not be checked or classified, even after retrying
+func (dr Result) Unknown() bool {
+	return !dr.Status.Known()
+}
+
+// Disputed return true if the DNS servers asked by a QuorumChecker did not agree on the domain
+func (dr Result) Disputed() bool {
+	return dr.Err == ErrDisputed
 }
 
-// Returns true if domain has a Name Server associated
-func queryNS(domain string, dnsServers []string, proto string) (int, error) {
-	c := new(dns.Client)
-	c.ReadTimeout = time.Duration(2 * time.Second)
-	c.WriteTimeout = time.Duration(2 * time.Second)
-	c.Net = proto
-	m := new(dns.Msg)
-	m.RecursionDesired = true
-	dnsServer := dnsServers[rand.Intn(len(dnsServers))]
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeNS)
-	in, _, err := c.Exchange(m, dnsServer+":53")
-	if err == nil {
-		return in.Rcode, err
+// Checker checks the availability of a single domain. A Result with a non nil error means the check could not be
+// completed, and its status is unknown. Checks should give up waiting and retrying once ctx is done.
+type Checker interface {
+	Check(ctx context.Context, domain string) Result
+}
+
+// Chain is a Checker that asks each Checker in order, stopping at the first one that fails or reports the domain as
+// taken. It allows a cheap backend to be confirmed by a stricter one. Checkers answering ErrUnsupported are skipped.
+// The Result is the one of the last Checker asked, with the attempts of all of them.
+type Chain []Checker
+
+// Check implements Checker
+func (c Chain) Check(ctx context.Context, domain string) Result {
+	r := Result{Domain: domain, Status: StatusError, Rcode: dns.RcodeServerFailure, Err: ErrUnsupported}
+	for _, checker := range c {
+		next := checker.Check(ctx, domain)
+		if next.Err == ErrUnsupported {
+			continue
+		}
+		next.Attempts += r.Attempts
+		if !r.Started.IsZero() {
+			next.Started = r.Started
+		}
+		r = next
+		if !r.Available() {
+			return r
+		}
 	}
-	return dns.RcodeRefused, err
+	return r
 }
 
-// CheckDomains check if each domain
-func CheckDomains(id int, in, retries chan string, out chan Result, dnsServers []string, proto string) {
+// CheckDomains check each domain received from in with checker, until in is closed or ctx is done. Every domain
+// produces exactly one Result, retries are left to the checker. Checks interrupted by ctx produce no Result, so the
+// caller can tell them apart from unknown ones.
+func CheckDomains(ctx context.Context, id int, in chan string, out chan Result, checker Checker) {
 	for {
-		var domain string
 		select {
-		case domain = <-in:
-		case domain = <-retries:
-		}
-		rCode, err := queryNS(domain, dnsServers, proto)
-		if err != nil {
-			retries <- domain
-		} else {
-			out <- Result{domain, rCode, err}
+		case <-ctx.Done():
+			return
+		case domain, ok := <-in:
+			if !ok {
+				return
+			}
+			r := checker.Check(ctx, domain)
+			if r.Err != nil && ctx.Err() != nil {
+				continue
+			}
+			out <- r
 		}
 	}
 }
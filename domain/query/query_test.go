@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+type fakeChecker struct {
+	rcode int
+	err   error
+	calls int
+}
+
+func (f *fakeChecker) Check(ctx context.Context, domain string) Result {
+	f.calls++
+	return DefaultRcodePolicy.apply(Result{Domain: domain, Rcode: f.rcode, Err: f.err})
+}
+
+func TestChainStopsAtTaken(t *testing.T) {
+	first := &fakeChecker{rcode: dns.RcodeSuccess}
+	second := &fakeChecker{rcode: dns.RcodeNameError}
+	r := Chain{first, second}.Check(context.Background(), "example.com")
+	if r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", "taken", "available")
+	}
+	if second.calls != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", 0, second.calls)
+	}
+}
+
+func TestChainConfirmsAvailable(t *testing.T) {
+	first := &fakeChecker{rcode: dns.RcodeNameError}
+	second := &fakeChecker{rcode: dns.RcodeSuccess}
+	r := Chain{first, second}.Check(context.Background(), "example.com")
+	if r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", "taken", "available")
+	}
+	if second.calls != 1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", 1, second.calls)
+	}
+}
+
+func TestChainStopsAtError(t *testing.T) {
+	first := &fakeChecker{err: errors.New("timeout")}
+	second := &fakeChecker{rcode: dns.RcodeNameError}
+	r := Chain{first, second}.Check(context.Background(), "example.com")
+	if r.Err == nil {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", "error", nil)
+	}
+	if second.calls != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", 0, second.calls)
+	}
+}
+
+func TestCheckDomainsStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in, out := make(chan string), make(chan Result, 1)
+	done := make(chan struct{})
+	go func() {
+		CheckDomains(ctx, 0, in, out, &fakeChecker{rcode: dns.RcodeNameError})
+		close(done)
+	}()
+	in <- "example.com"
+	if r := <-out; !r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "CheckDomains", "available", r)
+	}
+	cancel()
+	<-done
+}
+
+func TestResultString(t *testing.T) {
+	ns := mustRR("example.com. 3600 IN NS ns1.example.net.").(*dns.NS)
+	r := Result{Domain: "example.com", Status: StatusRegistered, Rcode: dns.RcodeSuccess, Server: "8.8.8.8",
+		Transport: "udp",
+		RTT:       20 * time.Millisecond, Attempts: 2, NS: []*dns.NS{ns}}
+	expected := "example.com\tREGISTERED\tNOERROR\t\"\"\t\t8.8.8.8\tudp\t20ms\t2\tns1.example.net.\n"
+	if out := r.String(false); out != expected {
+		t.Errorf(tests.ErrFmtExpectedGot, "String", expected, out)
+	}
+
+	r = Result{Domain: "free.com", Status: StatusError, Rcode: dns.RcodeServerFailure, Err: errors.New("timeout"),
+		Attempts: 4,
+		SOA:      mustRR("com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400").(*dns.SOA)}
+	expected = "free.com\tERROR\tSERVFAIL\t\"timeout\"\t\t\t\t0s\t4\tSOA com.\n"
+	if out := r.String(false); out != expected {
+		t.Errorf(tests.ErrFmtExpectedGot, "String", expected, out)
+	}
+	if out := r.String(true); out != "free.com\tERROR\n" {
+		t.Errorf(tests.ErrFmtExpectedGot, "String", "free.com\tERROR\n", out)
+	}
+}
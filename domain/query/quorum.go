@@ -0,0 +1,99 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrDisputed is the error of a Result when the DNS servers asked by a QuorumChecker did not agree
+var ErrDisputed = errors.New("DNS servers disagree")
+
+// Answer is what a single DNS server said about a domain
+type Answer struct {
+	Server string
+	Status Status
+	Rcode  int
+	Err    error // why the server could not answer, if it didn't
+}
+
+// Format Answer as server=STATUS
+func (a Answer) String() string {
+	return fmt.Sprintf("%s=%s", a.Server, a.Status)
+}
+
+// QuorumChecker is a Checker asking Voters distinct servers of NS about each domain. A domain only gets a Status when
+// at least Quorum of them agree on it, otherwise the Result is ErrDisputed. Failed queries are retried on
+// other servers according to NS.Retry. No server votes twice: when there are no more servers left to ask, the domain
+// gets fewer votes.
+type QuorumChecker struct {
+	NS     *NSChecker
+	Voters int
+	Quorum int
+}
+
+// NewQuorumChecker returns a QuorumChecker asking voters servers, and trusting the majority of them
+func NewQuorumChecker(ns *NSChecker, voters int) *QuorumChecker {
+	return &QuorumChecker{NS: ns, Voters: voters, Quorum: voters/2 + 1}
+}
+
+// Check implements Checker
+func (c *QuorumChecker) Check(ctx context.Context, domain string) Result {
+	var (
+		started  = time.Now()
+		attempts int
+		voted    []string // servers with an Answer, never asked again
+		answers  []Answer
+		votes    = map[Status][]Result{}
+	)
+	for len(answers) < c.Voters {
+		var dnsServer string
+		tried := voted
+		r := c.NS.Retry.Do(ctx, func(attempt int) Result {
+			dnsServer = c.NS.Servers.Pick(tried...)
+			if contains(voted, dnsServer) {
+				c.NS.Servers.Release(dnsServer)
+				return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: ErrNoServers}
+			}
+			tried = append(tried[:len(tried):len(tried)], dnsServer)
+			return c.NS.checkWith(ctx, domain, dnsServer)
+		})
+		if ctx.Err() != nil {
+			return Result{Domain: domain, Status: errorStatus(ctx.Err()), Rcode: dns.RcodeServerFailure, Err: ctx.Err()}
+		}
+		attempts += r.Attempts
+		if r.Err == ErrNoServers {
+			break
+		}
+		voted = append(voted, dnsServer)
+		answers = append(answers, Answer{Server: dnsServer, Status: r.Status, Rcode: r.Rcode, Err: r.Err})
+		if !r.Unknown() {
+			votes[r.Status] = append(votes[r.Status], r)
+		}
+	}
+
+	var winners []Status
+	for status, results := range votes {
+		if len(results) >= c.Quorum {
+			winners = append(winners, status)
+		}
+	}
+	r := Result{Domain: domain, Status: StatusUnknown, Rcode: dns.RcodeServerFailure, Err: ErrDisputed}
+	if len(answers) > 0 {
+		r.Rcode = answers[0].Rcode
+	}
+	if len(winners) == 1 {
+		r = votes[winners[0]][0]
+		for _, vote := range votes[winners[0]] {
+			r.Proven = r.Proven && vote.Proven
+			if vote.negativeTTL < r.negativeTTL {
+				r.negativeTTL = vote.negativeTTL
+			}
+		}
+	}
+	r.Answers, r.Attempts, r.Started, r.Finished = answers, attempts, started, time.Now()
+	return r
+}
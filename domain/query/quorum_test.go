@@ -0,0 +1,90 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func TestQuorumChecker(t *testing.T) {
+	var servers []string
+	for i := 0; i < 3; i++ {
+		addr, stop := startDNSServer(t, probeHandler(i == 2, ""))
+		defer stop()
+		servers = append(servers, addr)
+	}
+	c := NewQuorumChecker(NewNSChecker(servers, NewDNSTransport("udp")), 3)
+
+	r := c.Check(context.Background(), "free.test")
+	if !r.Available() || len(r.Answers) != 3 {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "available by majority", r.String(false))
+	}
+	seen := map[string]bool{}
+	for _, answer := range r.Answers {
+		seen[answer.Server] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Check", "3 distinct servers", r.Answers)
+	}
+
+	c.Quorum = 3
+	r = c.Check(context.Background(), "free.test")
+	if !r.Disputed() || r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "disputed", r.String(false))
+	}
+	out := r.String(true)
+	if !strings.HasPrefix(out, "free.test\tDISPUTED\t") || !strings.Contains(out, servers[2]+"=RESERVED") ||
+		!strings.Contains(out, servers[0]+"=AVAILABLE") {
+		t.Errorf(tests.ErrFmtExpectedGot, "String", "DISPUTED with answers", out)
+	}
+}
+
+func TestQuorumCheckerWithTimeouts(t *testing.T) {
+	var servers []string
+	for _, handler := range []dns.Handler{
+		dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {}), // never answers
+		probeHandler(false, ""),
+		probeHandler(true, ""),
+	} {
+		addr, stop := startDNSServer(t, handler)
+		defer stop()
+		servers = append(servers, addr)
+	}
+	transport := NewDNSTransport("udp")
+	transport.Timeouts.Read = 50 * time.Millisecond
+	ns := NewNSChecker(servers, transport)
+	ns.Retry.BaseDelay = 0
+	ns.Servers.MaxFailureRate = 1
+	c := NewQuorumChecker(ns, 3)
+
+	for i := 0; i < 5; i++ {
+		r := c.Check(context.Background(), "free.test")
+		if !r.Disputed() || r.Available() {
+			t.Errorf(tests.ErrFmtExpectedGot, "Check", "disputed", r.String(false))
+		}
+		seen := map[string]bool{}
+		for _, answer := range r.Answers {
+			if seen[answer.Server] {
+				t.Errorf(tests.ErrFmtExpectedGotV, "Check", "distinct voters", r.Answers)
+			}
+			seen[answer.Server] = true
+		}
+	}
+
+	// a vote answered after ctx is done does not make a Result on its own
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr, stop := startDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		cancel()
+		probeHandler(false, "").ServeDNS(w, req)
+	}))
+	defer stop()
+	c = NewQuorumChecker(NewNSChecker([]string{addr, servers[1]}, NewDNSTransport("udp")), 2)
+	if r := c.Check(ctx, "free.test"); r.Err != context.Canceled {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Check", context.Canceled, r.Err)
+	}
+}
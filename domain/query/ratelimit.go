@@ -0,0 +1,85 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second, with bursts of up to burst events
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter limits the queries per second sent to each server and, optionally, to all of them together. A zero
+// rate means no limit.
+type RateLimiter struct {
+	PerServer float64
+
+	mu      sync.Mutex
+	global  *tokenBucket
+	servers map[string]*tokenBucket
+}
+
+// NewRateLimiter returns a RateLimiter allowing perServer queries per second to each server and global queries per
+// second overall
+func NewRateLimiter(perServer, global float64) *RateLimiter {
+	l := &RateLimiter{PerServer: perServer, servers: map[string]*tokenBucket{}}
+	if global > 0 {
+		l.global = newTokenBucket(global)
+	}
+	return l
+}
+
+// Wait blocks until a query to server is allowed or ctx is done. A nil RateLimiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context, server string) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	var delay time.Duration
+	if l.PerServer > 0 {
+		l.mu.Lock()
+		bucket, ok := l.servers[server]
+		if !ok {
+			bucket = newTokenBucket(l.PerServer)
+			l.servers[server] = bucket
+		}
+		l.mu.Unlock()
+		delay = bucket.reserve()
+	}
+	if l.global != nil {
+		if d := l.global.reserve(); d > delay {
+			delay = d
+		}
+	}
+	return sleep(ctx, delay)
+}
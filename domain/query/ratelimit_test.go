@@ -0,0 +1,61 @@
+package query
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func timeWaits(l *RateLimiter, servers []string, n int) time.Duration {
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				l.Wait(context.Background(), server)
+			}
+		}(server)
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+func TestRateLimiterPerServer(t *testing.T) {
+	l := NewRateLimiter(100, 0)
+	// 100 tokens of burst, then 10 more at 100 qps per server, in parallel
+	elapsed := timeWaits(l, []string{"a", "b"}, 110)
+	if elapsed < 90*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Wait", "~100ms", elapsed)
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	l := NewRateLimiter(0, 100)
+	// 100 tokens of burst, then 20 more at 100 qps for both servers together
+	elapsed := timeWaits(l, []string{"a", "b"}, 60)
+	if elapsed < 180*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Wait", "~200ms", elapsed)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	var l *RateLimiter
+	if elapsed := timeWaits(l, []string{"a"}, 1000); elapsed > 50*time.Millisecond {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Wait", "no wait", elapsed)
+	}
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	l := NewRateLimiter(1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	l.Wait(ctx, "a")
+	cancel()
+	if err := l.Wait(ctx, "a"); err != context.Canceled {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Wait", context.Canceled, err)
+	}
+}
@@ -0,0 +1,130 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hgfischer/domainerator/wordlist"
+	"github.com/miekg/dns"
+)
+
+// RcodeAction tells a checker what to do with a DNS answer code
+type RcodeAction int
+
+// Possible actions for a DNS answer code
+const (
+	ActionUnknown   RcodeAction = iota // give up, the domain status is unknown
+	ActionRetry                        // ask again, on another server
+	ActionAvailable                    // final answer, the domain is available
+	ActionTaken                        // final answer, the domain is taken
+)
+
+var rcodeActionNames = map[string]RcodeAction{
+	"unknown":   ActionUnknown,
+	"retry":     ActionRetry,
+	"available": ActionAvailable,
+	"taken":     ActionTaken,
+}
+
+// DefaultRcodePolicy treats NXDOMAIN as available, NOERROR as taken and asks again on SERVFAIL and REFUSED
+var DefaultRcodePolicy = RcodePolicy{
+	dns.RcodeSuccess:        ActionTaken,
+	dns.RcodeNameError:      ActionAvailable,
+	dns.RcodeServerFailure:  ActionRetry,
+	dns.RcodeRefused:        ActionRetry,
+	dns.RcodeFormatError:    ActionUnknown,
+	dns.RcodeNotImplemented: ActionUnknown,
+}
+
+// RcodePolicy maps DNS answer codes to actions. Codes not in the table are unknown.
+type RcodePolicy map[int]RcodeAction
+
+// ParseRcodePolicy parses a CSV string of RCODE=action pairs (ex.: "SERVFAIL=retry,REFUSED=unknown") on top of
+// DefaultRcodePolicy
+func ParseRcodePolicy(csv string) (RcodePolicy, error) {
+	policy := RcodePolicy{}
+	for rCode, action := range DefaultRcodePolicy {
+		policy[rCode] = action
+	}
+	for _, pair := range wordlist.FromCSV(csv) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid rcode policy entry %q (should be RCODE=action)", pair)
+		}
+		rCode, ok := stringToRcode(parts[0])
+		if !ok {
+			return nil, fmt.Errorf("Unknown rcode %q", parts[0])
+		}
+		action, ok := rcodeActionNames[strings.ToLower(parts[1])]
+		if !ok {
+			return nil, fmt.Errorf("Unknown rcode action %q (should be retry, available, taken or unknown)", parts[1])
+		}
+		policy[rCode] = action
+	}
+	return policy, nil
+}
+
+// Action returns what to do with rCode
+func (p RcodePolicy) Action(rCode int) RcodeAction {
+	if p == nil {
+		return DefaultRcodePolicy[rCode]
+	}
+	return p[rCode]
+}
+
+// apply sets the verdict of a DNS Result according to its rcode
+func (p RcodePolicy) apply(r Result) Result {
+	switch p.Action(r.Rcode) {
+	case ActionAvailable:
+		r.Status = StatusAvailable
+	case ActionTaken:
+		r.Status = StatusRegistered
+	case ActionRetry:
+		r.Status, r.Err = StatusError, &rcodeError{rCode: r.Rcode, retry: true}
+	default:
+		r.Status, r.Err = StatusUnknown, &rcodeError{rCode: r.Rcode}
+	}
+	return r
+}
+
+// rcodeAliases are names of rcodes spelled differently by some miekg/dns versions
+var rcodeAliases = map[string]int{
+	"NOTIMP":  dns.RcodeNotImplemented,
+	"NOTIMPL": dns.RcodeNotImplemented,
+}
+
+func stringToRcode(s string) (int, bool) {
+	s = strings.ToUpper(s)
+	if rCode, ok := rcodeAliases[s]; ok {
+		return rCode, true
+	}
+	for rCode, name := range dns.RcodeToString {
+		if name == s {
+			return rCode, true
+		}
+	}
+	return 0, false
+}
+
+// rcodeError is set on Results whose rcode does not give a final answer
+type rcodeError struct {
+	rCode int
+	retry bool
+}
+
+func (e *rcodeError) Error() string {
+	return fmt.Sprintf("%s answer", dns.RcodeToString[e.rCode])
+}
+
+// retriable tells if a check that failed with err should be attempted again
+func retriable(err error) bool {
+	switch err {
+	case nil, ErrUnsupported, ErrDisputed, ErrNoServers, context.Canceled, context.DeadlineExceeded:
+		return false
+	}
+	if e, ok := err.(*rcodeError); ok {
+		return e.retry
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func TestParseRcodePolicy(t *testing.T) {
+	policy, err := ParseRcodePolicy("servfail=unknown, NOTIMPL=retry")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseRcodePolicy", "No Error", err)
+	}
+	expected := map[int]RcodeAction{
+		dns.RcodeSuccess:        ActionTaken,
+		dns.RcodeNameError:      ActionAvailable,
+		dns.RcodeServerFailure:  ActionUnknown,
+		dns.RcodeRefused:        ActionRetry,
+		dns.RcodeNotImplemented: ActionRetry,
+		dns.RcodeYXDomain:       ActionUnknown,
+	}
+	for rCode, action := range expected {
+		if policy.Action(rCode) != action {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Action", action, policy.Action(rCode))
+		}
+	}
+	for _, csv := range []string{"NOTIMP=retry", "notimpl=retry"} {
+		if policy, err := ParseRcodePolicy(csv); err != nil || policy.Action(dns.RcodeNotImplemented) != ActionRetry {
+			t.Errorf(tests.ErrFmtExpectedGotV, "ParseRcodePolicy", ActionRetry, policy.Action(dns.RcodeNotImplemented))
+		}
+	}
+	for _, csv := range []string{"SERVFAIL", "NOPE=retry", "SERVFAIL=maybe"} {
+		if _, err := ParseRcodePolicy(csv); err == nil {
+			t.Errorf(tests.ErrFmtExpectedGot, "ParseRcodePolicy", "Error", "No Error")
+		}
+	}
+}
+
+func TestRcodePolicyApply(t *testing.T) {
+	policy := RcodePolicy{dns.RcodeServerFailure: ActionAvailable, dns.RcodeRefused: ActionRetry}
+	if r := policy.apply(Result{Rcode: dns.RcodeServerFailure}); !r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "apply", "available", r)
+	}
+	if r := policy.apply(Result{Rcode: dns.RcodeRefused}); !retriable(r.Err) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "apply", "retriable error", r.Err)
+	}
+	if r := policy.apply(Result{Rcode: dns.RcodeNameError}); !r.Unknown() || retriable(r.Err) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "apply", "final error", r.Err)
+	}
+}
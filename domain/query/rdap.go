@@ -0,0 +1,133 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RDAPBootstrap maps TLDs to their RDAP base URLs
+type RDAPBootstrap map[string][]string
+
+// rdapBootstrapFile is the layout of the IANA RDAP bootstrap file for DNS (https://data.iana.org/rdap/dns.json)
+type rdapBootstrapFile struct {
+	Services [][][]string `json:"services"`
+}
+
+// rdapDomain is the part of a RDAP domain object we care about
+type rdapDomain struct {
+	Status []string `json:"status"`
+}
+
+// LoadRDAPBootstrap reads an IANA RDAP bootstrap file from disk
+func LoadRDAPBootstrap(path string) (RDAPBootstrap, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRDAPBootstrap(content)
+}
+
+// ParseRDAPBootstrap parses the content of an IANA RDAP bootstrap file
+func ParseRDAPBootstrap(content []byte) (RDAPBootstrap, error) {
+	var file rdapBootstrapFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("Invalid RDAP bootstrap file: %s", err)
+	}
+	bootstrap := RDAPBootstrap{}
+	for _, service := range file.Services {
+		if len(service) != 2 {
+			return nil, fmt.Errorf("Invalid RDAP bootstrap service entry: %q", service)
+		}
+		for _, tld := range service[0] {
+			bootstrap[strings.ToLower(tld)] = service[1]
+		}
+	}
+	return bootstrap, nil
+}
+
+// BaseURL returns the RDAP base URL responsible for domain, preferring HTTPS ones
+func (b RDAPBootstrap) BaseURL(domain string) (string, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i := 1; i < len(labels); i++ {
+		urls, ok := b[strings.Join(labels[i:], ".")]
+		if !ok || len(urls) == 0 {
+			continue
+		}
+		for _, u := range urls {
+			if strings.HasPrefix(u, "https://") {
+				return u, true
+			}
+		}
+		return urls[0], true
+	}
+	return "", false
+}
+
+// RDAPChecker is a Checker that looks up domains in the RDAP service of their TLD. A 404 answer means the domain is
+// available, and it is reported as NXDOMAIN so it can be handled like a DNS Result.
+type RDAPChecker struct {
+	Bootstrap RDAPBootstrap
+	Client    *http.Client
+	Retry     RetryPolicy
+}
+
+// NewRDAPChecker returns a RDAPChecker using bootstrap to find RDAP services
+func NewRDAPChecker(bootstrap RDAPBootstrap) *RDAPChecker {
+	return &RDAPChecker{
+		Bootstrap: bootstrap,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		Retry:     DefaultRetryPolicy,
+	}
+}
+
+// Check implements Checker
+func (c *RDAPChecker) Check(ctx context.Context, domain string) Result {
+	base, ok := c.Bootstrap.BaseURL(domain)
+	if !ok {
+		return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: ErrUnsupported}
+	}
+	return c.Retry.Do(ctx, func(attempt int) Result {
+		start := time.Now()
+		statuses, rCode, err := c.queryDomain(ctx, base, domain)
+		r := Result{Domain: domain, Status: StatusAvailable, Rcode: rCode, RegistryStatus: statuses, Err: err,
+			Server: base, Transport: "rdap", RTT: time.Since(start)}
+		if rCode == dns.RcodeSuccess {
+			r.Status = registryStatus(statuses)
+		}
+		return r
+	})
+}
+
+func (c *RDAPChecker) queryDomain(ctx context.Context, base, domain string) ([]string, int, error) {
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	req, err := http.NewRequest("GET", base+"domain/"+strings.ToLower(domain), nil)
+	if err != nil {
+		return nil, dns.RcodeServerFailure, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, dns.RcodeServerFailure, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var object rdapDomain
+		if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+			return nil, dns.RcodeServerFailure, fmt.Errorf("Invalid RDAP answer for %q: %s", domain, err)
+		}
+		return object.Status, dns.RcodeSuccess, nil
+	case http.StatusNotFound:
+		return nil, dns.RcodeNameError, nil
+	}
+	return nil, dns.RcodeServerFailure, fmt.Errorf("RDAP server answered %q for %q", resp.Status, domain)
+}
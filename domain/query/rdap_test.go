@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func newRDAPTestServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/domain/taken.test", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprint(w, `{"objectClassName":"domain","ldhName":"taken.test","status":["active"]}`)
+	})
+	mux.HandleFunc("/domain/held.test", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		fmt.Fprint(w, `{"objectClassName":"domain","ldhName":"held.test","status":["redemption period","server hold"]}`)
+	})
+	mux.HandleFunc("/domain/broken.test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	return httptest.NewServer(mux)
+}
+
+func newRDAPTestChecker(url string) *RDAPChecker {
+	content := fmt.Sprintf(`{"version":"1.0","services":[[["test","example"],["%s"]]]}`, url)
+	bootstrap, err := ParseRDAPBootstrap([]byte(content))
+	if err != nil {
+		panic(err)
+	}
+	checker := NewRDAPChecker(bootstrap)
+	checker.Retry = RetryPolicy{MaxAttempts: 2}
+	return checker
+}
+
+func TestParseRDAPBootstrap(t *testing.T) {
+	content := `{"services":[[["com","NET"],["https://rdap.example/com/"]],[["org"],["http://a/","https://b/"]]]}`
+	bootstrap, err := ParseRDAPBootstrap([]byte(content))
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseRDAPBootstrap", "No Error", err)
+	}
+	expected := RDAPBootstrap{
+		"com": {"https://rdap.example/com/"},
+		"net": {"https://rdap.example/com/"},
+		"org": {"http://a/", "https://b/"},
+	}
+	if !reflect.DeepEqual(expected, bootstrap) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseRDAPBootstrap", expected, bootstrap)
+	}
+	if url, _ := bootstrap.BaseURL("www.example.org"); url != "https://b/" {
+		t.Errorf(tests.ErrFmtExpectedGot, "BaseURL", "https://b/", url)
+	}
+	if _, ok := bootstrap.BaseURL("example.de"); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "BaseURL", false, ok)
+	}
+}
+
+func TestRDAPChecker(t *testing.T) {
+	server := newRDAPTestServer()
+	defer server.Close()
+	checker := newRDAPTestChecker(server.URL)
+
+	if r := checker.Check(context.Background(), "free.test"); r.Err != nil || !r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "RDAPChecker.Check", "available", r)
+	}
+	if r := checker.Check(context.Background(), "taken.test"); r.Status != StatusRegistered {
+		t.Errorf(tests.ErrFmtExpectedGotV, "RDAPChecker.Check", "taken", r)
+	}
+	r := checker.Check(context.Background(), "held.test")
+	expected := []string{"redemption period", "server hold"}
+	if r.Status != StatusOnHold || !reflect.DeepEqual(expected, r.RegistryStatus) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "RDAPChecker.Check", expected, r.RegistryStatus)
+	}
+	if r := checker.Check(context.Background(), "broken.test"); r.Err == nil || r.Status != StatusError {
+		t.Errorf(tests.ErrFmtExpectedGotV, "RDAPChecker.Check", "error", r)
+	}
+	if r := checker.Check(context.Background(), "free.de"); r.Err != ErrUnsupported {
+		t.Errorf(tests.ErrFmtExpectedGotV, "RDAPChecker.Check", ErrUnsupported, r.Err)
+	}
+}
+
+func TestChainSkipsUnsupported(t *testing.T) {
+	server := newRDAPTestServer()
+	defer server.Close()
+	first := &fakeChecker{rcode: dns.RcodeNameError}
+	r := Chain{first, newRDAPTestChecker(server.URL)}.Check(context.Background(), "free.de")
+	if r.Err != nil || !r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Chain.Check", "available", r)
+	}
+}
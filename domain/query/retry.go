@@ -0,0 +1,70 @@
+package query
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryPolicy is used by checkers created without an explicit RetryPolicy
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 250 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+// RetryPolicy bounds how many times a failed check is attempted and how long to wait between attempts
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first one
+	BaseDelay   time.Duration // delay before the second attempt, doubled on each following one
+	MaxDelay    time.Duration // upper limit for the delay between attempts
+}
+
+// Backoff returns how long to wait after a failed attempt (starting at 1). The delay grows exponentially and half of
+// it is random jitter, so workers failing together don't retry together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Do calls check until it returns a final Result or MaxAttempts is reached, and returns the last Result, with the
+// attempts made and when they started and finished. Failed Results get their Status from the error. check receives
+// the attempt number (starting at 0) so it can pick a different server each time. Retries stop when ctx is done.
+func (p RetryPolicy) Do(ctx context.Context, check func(attempt int) Result) Result {
+	started := time.Now()
+	r := check(0)
+	attempts := 1
+	for ; attempts < p.MaxAttempts && retriable(r.Err); attempts++ {
+		if err := sleep(ctx, p.Backoff(attempts)); err != nil {
+			r.Err = err
+			break
+		}
+		r = check(attempts)
+	}
+	r.Attempts, r.Started, r.Finished = attempts, started, time.Now()
+	if r.Err != nil {
+		r.Status = errorStatus(r.Err)
+	}
+	return r
+}
+
+// sleep pauses for d, returning early with an error if ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	bounds := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, max := range bounds {
+		max *= time.Millisecond
+		delay := p.Backoff(i + 1)
+		if delay < max/2 || delay > max {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Backoff", max, delay)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	var attempts []int
+	r := p.Do(context.Background(), func(attempt int) Result {
+		attempts = append(attempts, attempt)
+		return Result{Domain: "example.com", Rcode: dns.RcodeServerFailure, Err: errors.New("timeout")}
+	})
+	if len(attempts) != 3 || attempts[2] != 2 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Do", []int{0, 1, 2}, attempts)
+	}
+	if !r.Unknown() || r.Attempts != 3 || r.Finished.Before(r.Started) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Do", "unknown after 3 attempts", r)
+	}
+
+	attempts = nil
+	r = p.Do(context.Background(), func(attempt int) Result {
+		attempts = append(attempts, attempt)
+		if attempt == 0 {
+			return Result{Domain: "example.com", Err: errors.New("timeout")}
+		}
+		return Result{Domain: "example.com", Rcode: dns.RcodeNameError, Status: StatusAvailable}
+	})
+	if len(attempts) != 2 || !r.Available() || r.Attempts != 2 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Do", "available after 2 attempts", r)
+	}
+}
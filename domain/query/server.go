@@ -0,0 +1,250 @@
+package query
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResolvConf is where ResolvConfServers looks for the DNS servers of the system
+const ResolvConf = "/etc/resolv.conf"
+
+// Server is a DNS server parsed from a spec like 8.8.8.8, [2001:db8::1]:53, 127.0.0.1:5353,
+// tls://1.1.1.1;name=cloudflare-dns.com or https://dns.google/dns-query;method=POST. Options follow the address,
+// separated by semicolons:
+//
+//	name=host     server name expected in the TLS certificate
+//	insecure      do not verify the TLS certificate
+//	method=POST   HTTP method for https (GET or POST)
+//	timeout=5s    time to wait for connections and answers (dial, write and read)
+type Server struct {
+	Spec    string // as given, and how the server is known everywhere else
+	Proto   string // udp, tcp, tls or https
+	Addr    string // host:port, or the endpoint URL for https
+	Options map[string]string
+}
+
+// defaultPorts by protocol
+var defaultPorts = map[string]string{"udp": "53", "tcp": "53", "tls": "853"}
+
+// ParseServer parses a server spec, using proto when it has no scheme
+func ParseServer(spec, proto string) (*Server, error) {
+	spec = strings.TrimSpace(spec)
+	parts := strings.Split(spec, ";")
+	s := &Server{Spec: spec, Proto: proto, Options: map[string]string{}}
+	addr := parts[0]
+	if i := strings.Index(addr, "://"); i >= 0 {
+		s.Proto, addr = strings.ToLower(addr[:i]), addr[i+3:]
+	}
+	var err error
+	switch s.Proto {
+	case "udp", "tcp", "tls":
+		s.Addr, err = parseHostPort(strings.TrimSuffix(addr, "/"), defaultPorts[s.Proto])
+	case "https":
+		s.Addr, err = parseDoHURL(addr)
+	default:
+		err = fmt.Errorf("Unknown protocol: %q", s.Proto)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid DNS server %q: %s", spec, err)
+	}
+	for _, option := range parts[1:] {
+		if err := s.setOption(option); err != nil {
+			return nil, fmt.Errorf("Invalid DNS server %q: %s", spec, err)
+		}
+	}
+	return s, nil
+}
+
+func parseHostPort(addr, defaultPort string) (string, error) {
+	if isIP(addr) {
+		return net.JoinHostPort(addr, defaultPort), nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), defaultPort
+	}
+	if host == "" || strings.ContainsAny(host, "/[]") || (strings.Contains(host, ":") && !isIP(host)) {
+		return "", fmt.Errorf("bad host %q", host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("bad port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func parseDoHURL(addr string) (string, error) {
+	u, err := url.Parse("https://" + addr)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("no host")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = DefaultDoHPath
+	}
+	return u.String(), nil
+}
+
+func (s *Server) setOption(option string) error {
+	kv := strings.SplitN(option, "=", 2)
+	key, value := strings.TrimSpace(kv[0]), ""
+	if len(kv) == 2 {
+		value = strings.TrimSpace(kv[1])
+	}
+	var err error
+	switch key {
+	case "name":
+		if value == "" {
+			err = fmt.Errorf("empty name")
+		}
+	case "insecure":
+		if value == "" {
+			value = "true"
+		}
+		_, err = strconv.ParseBool(value)
+	case "method":
+		value = strings.ToUpper(value)
+		if value != http.MethodGet && value != http.MethodPost {
+			err = fmt.Errorf("bad method %q", value)
+		}
+	case "timeout":
+		_, err = time.ParseDuration(value)
+	default:
+		err = fmt.Errorf("unknown option %q", key)
+	}
+	s.Options[key] = value
+	return err
+}
+
+// ParseServers parses a comma-separated list of server specs, using proto for the ones without a scheme
+func ParseServers(csv, proto string) ([]*Server, error) {
+	var servers []*Server
+	seen := map[string]bool{}
+	for _, spec := range strings.Split(csv, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" || seen[spec] {
+			continue
+		}
+		seen[spec] = true
+		s, err := ParseServer(spec, proto)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	sort.Sort(bySpec(servers))
+	return servers, nil
+}
+
+// ResolvConfServers returns the nameservers of a resolv.conf file, talking proto
+func ResolvConfServers(path, proto string) ([]*Server, error) {
+	config, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []string
+	for _, server := range config.Servers {
+		specs = append(specs, net.JoinHostPort(server, config.Port))
+	}
+	return ParseServers(strings.Join(specs, ","), proto)
+}
+
+// isIP tells if host is an IP address, IPv6 ones with a zone (fe80::1%eth0) included
+func isIP(host string) bool {
+	if i := strings.LastIndex(host, "%"); i > 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	return net.ParseIP(host) != nil
+}
+
+type bySpec []*Server
+
+func (s bySpec) Len() int           { return len(s) }
+func (s bySpec) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySpec) Less(i, j int) bool { return s[i].Spec < s[j].Spec }
+
+// ServerTransport is a Transport for a set of Servers, sending each query to the address of its server (by Spec),
+// with the protocol and options of that server. UDP servers share the sockets of Pool.
+type ServerTransport struct {
+	Pool *UDPPool
+
+	servers    map[string]*Server
+	transports map[string]Transport
+}
+
+// NewServerTransport returns a ServerTransport for servers. Options of each server override tlsConfig, and the
+// dohMethod and timeouts used by default.
+func NewServerTransport(servers []*Server, tlsConfig *tls.Config, dohMethod string,
+	timeouts Timeouts) *ServerTransport {
+	t := &ServerTransport{Pool: NewUDPPool(), servers: map[string]*Server{}, transports: map[string]Transport{}}
+	for _, s := range servers {
+		t.servers[s.Spec] = s
+		t.transports[s.Spec] = s.transport(tlsConfig, dohMethod, timeouts, t.Pool)
+	}
+	return t
+}
+
+// Exchange implements Transport
+func (t *ServerTransport) Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	s, ok := t.servers[server]
+	if !ok {
+		return nil, 0, fmt.Errorf("Unknown DNS server %q", server)
+	}
+	return t.transports[server].Exchange(m, s.Addr)
+}
+
+// Proto implements Transport
+func (t *ServerTransport) Proto(server string) string {
+	if s, ok := t.servers[server]; ok {
+		return s.Proto
+	}
+	return ""
+}
+
+// transport returns a Transport for the protocol and options of s, sending UDP queries through pool
+func (s *Server) transport(tlsConfig *tls.Config, dohMethod string, timeouts Timeouts, pool *UDPPool) Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	if name, ok := s.Options["name"]; ok {
+		tlsConfig.ServerName = name
+	}
+	if insecure, ok := s.Options["insecure"]; ok {
+		tlsConfig.InsecureSkipVerify, _ = strconv.ParseBool(insecure)
+	}
+	if value, ok := s.Options["timeout"]; ok {
+		timeout, _ := time.ParseDuration(value)
+		timeouts = Timeouts{timeout, timeout, timeout}
+	}
+	switch s.Proto {
+	case "https":
+		if method, ok := s.Options["method"]; ok {
+			dohMethod = method
+		}
+		t := NewDoHTransport(dohMethod, tlsConfig)
+		t.SetTimeouts(timeouts)
+		return t
+	case "tls":
+		t := NewDNSTransport("tcp-tls")
+		t.TLSConfig = tlsConfig
+		t.Timeouts = timeouts
+		return t
+	case "udp":
+		t := NewUDPTransport(pool)
+		t.Timeouts = timeouts
+		return t
+	}
+	t := NewDNSTransport(s.Proto)
+	t.Timeouts = timeouts
+	return t
+}
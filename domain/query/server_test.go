@@ -0,0 +1,116 @@
+package query
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func TestParseServer(t *testing.T) {
+	for spec, expected := range map[string]Server{
+		"8.8.8.8":                   {Proto: "udp", Addr: "8.8.8.8:53"},
+		"127.0.0.1:5353":            {Proto: "udp", Addr: "127.0.0.1:5353"},
+		"2001:db8::1":               {Proto: "udp", Addr: "[2001:db8::1]:53"},
+		"[2001:db8::1]":             {Proto: "udp", Addr: "[2001:db8::1]:53"},
+		"[2001:db8::1]:5353":        {Proto: "udp", Addr: "[2001:db8::1]:5353"},
+		"fe80::1%eth0":              {Proto: "udp", Addr: "[fe80::1%eth0]:53"},
+		"dns.example":               {Proto: "udp", Addr: "dns.example:53"},
+		"tcp://8.8.8.8":             {Proto: "tcp", Addr: "8.8.8.8:53"},
+		"TLS://[2001:db8::1]/":      {Proto: "tls", Addr: "[2001:db8::1]:853"},
+		"https://dns.google":        {Proto: "https", Addr: "https://dns.google/dns-query"},
+		"https://doh.example/q?a=b": {Proto: "https", Addr: "https://doh.example/q?a=b"},
+		"tls://1.1.1.1;name=cloudflare-dns.com;insecure": {Proto: "tls", Addr: "1.1.1.1:853",
+			Options: map[string]string{"name": "cloudflare-dns.com", "insecure": "true"}},
+		"https://dns.google;method=post;timeout=5s": {Proto: "https", Addr: "https://dns.google/dns-query",
+			Options: map[string]string{"method": "POST", "timeout": "5s"}},
+	} {
+		s, err := ParseServer(spec, "udp")
+		if err != nil {
+			t.Errorf(tests.ErrFmtExpectedGot, "ParseServer", "No Error", err)
+			continue
+		}
+		if expected.Options == nil {
+			expected.Options = map[string]string{}
+		}
+		expected.Spec = spec
+		if !reflect.DeepEqual(*s, expected) {
+			t.Errorf(tests.ErrFmtExpectedGotV, "ParseServer", expected, *s)
+		}
+	}
+
+	for _, spec := range []string{"", "8.8.8.8:0", "8.8.8.8:dns", "a:b:c", "quic://8.8.8.8", "https://",
+		"8.8.8.8/x", "8.8.8.8;port=5", "https://dns.google;method=PUT", "tls://1.1.1.1;name="} {
+		if _, err := ParseServer(spec, "udp"); err == nil {
+			t.Errorf(tests.ErrFmtExpectedGot, "ParseServer", "Error for "+spec, "No Error")
+		}
+	}
+}
+
+func TestParseServers(t *testing.T) {
+	servers, err := ParseServers(" 8.8.4.4, tls://1.1.1.1,,8.8.4.4 ", "tcp")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseServers", "No Error", err)
+	}
+	if len(servers) != 2 || servers[0].Spec != "8.8.4.4" || servers[0].Proto != "tcp" || servers[1].Proto != "tls" {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseServers", "[8.8.4.4 tls://1.1.1.1]", servers)
+	}
+	if _, err := ParseServers("8.8.8.8,bad:port", "udp"); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ParseServers", "Error", "No Error")
+	}
+}
+
+func TestResolvConfServers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "domainerator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	content := "# test\nnameserver 127.0.0.53\nnameserver 2001:db8::1\nnameserver fe80::1%eth0\nsearch example\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	servers, err := ResolvConfServers(path, "udp")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ResolvConfServers", "No Error", err)
+	}
+	var addrs []string
+	for _, s := range servers {
+		addrs = append(addrs, s.Addr)
+	}
+	expected := []string{"127.0.0.53:53", "[2001:db8::1]:53", "[fe80::1%eth0]:53"}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ResolvConfServers", expected, addrs)
+	}
+}
+
+func TestServerTransport(t *testing.T) {
+	wild, stopWild := startDNSServer(t, probeHandler(true, ""))
+	defer stopWild()
+	plain, stopPlain := startDNSServer(t, probeHandler(false, ""))
+	defer stopPlain()
+	servers, err := ParseServers("udp://"+wild+";timeout=1s,"+plain, "udp")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseServers", "No Error", err)
+	}
+	transport := NewServerTransport(servers, nil, "GET", DefaultTimeouts)
+	defer transport.Pool.Close()
+
+	if rcode := exchangeRcode(t, transport, "udp://"+wild+";timeout=1s", "a.test."); rcode != dns.RcodeSuccess {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NOERROR", dns.RcodeToString[rcode])
+	}
+	if rcode := exchangeRcode(t, transport, plain, "a.test."); rcode != dns.RcodeNameError {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NXDOMAIN", dns.RcodeToString[rcode])
+	}
+	m := new(dns.Msg)
+	m.SetQuestion("a.test.", dns.TypeA)
+	if _, _, err := transport.Exchange(m, "192.0.2.1"); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "unknown server error", "No Error")
+	}
+}
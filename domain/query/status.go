@@ -0,0 +1,80 @@
+package query
+
+import "strings"
+
+// Status is what a check found out about a domain
+type Status int
+
+// Possible Status of a domain. Unknown and Error are not verdicts: the domain could not be classified (unknown
+// answer, servers that disagree) or checked at all (timeouts, server failures).
+const (
+	StatusUnknown    Status = iota
+	StatusAvailable         // can be registered
+	StatusRegistered        // taken
+	StatusReserved          // exists in the registry but can't be registered, like nic.TLD
+	StatusPremium           // can be registered, at a premium price
+	StatusOnHold            // taken, but held or about to be deleted by the registry
+	StatusError
+)
+
+var statusNames = map[Status]string{
+	StatusUnknown:    "UNKNOWN",
+	StatusAvailable:  "AVAILABLE",
+	StatusRegistered: "REGISTERED",
+	StatusReserved:   "RESERVED",
+	StatusPremium:    "PREMIUM",
+	StatusOnHold:     "ONHOLD",
+	StatusError:      "ERROR",
+}
+
+// String returns the name of the Status, as written to the output file
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return statusNames[StatusUnknown]
+}
+
+// parseStatus returns the Status named name by String
+func parseStatus(name string) (Status, bool) {
+	for status, statusName := range statusNames {
+		if statusName == name {
+			return status, true
+		}
+	}
+	return StatusUnknown, false
+}
+
+// Known tells if the Status is a verdict on the domain
+func (s Status) Known() bool {
+	return s != StatusUnknown && s != StatusError
+}
+
+// errorStatus returns the Status of a Result that failed with err
+func errorStatus(err error) Status {
+	if e, ok := err.(*rcodeError); (ok && !e.retry) || err == ErrDisputed {
+		return StatusUnknown
+	}
+	return StatusError
+}
+
+// Registry status values (RFC 8056) of domains that are registered but held, or going away
+var onHoldStatuses = []string{"client hold", "server hold", "redemption period", "pending restore", "pending delete"}
+
+// registryStatus returns the Status of a registered domain with the given registry status values. Some registries
+// also report reserved and premium names this way.
+func registryStatus(statuses []string) Status {
+	status := StatusRegistered
+	for _, s := range statuses {
+		s = strings.ToLower(s)
+		switch {
+		case strings.Contains(s, "reserved"):
+			return StatusReserved
+		case strings.Contains(s, "premium"):
+			status = StatusPremium
+		case status == StatusRegistered && contains(onHoldStatuses, s):
+			status = StatusOnHold
+		}
+	}
+	return status
+}
@@ -0,0 +1,38 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+func TestRegistryStatus(t *testing.T) {
+	for expected, statuses := range map[Status][]string{
+		StatusRegistered: {"active", "client transfer prohibited"},
+		StatusOnHold:     {"active", "server hold"},
+		StatusReserved:   {"server hold", "Reserved"},
+		StatusPremium:    {"pending delete", "premium"},
+	} {
+		if status := registryStatus(statuses); status != expected {
+			t.Errorf(tests.ErrFmtExpectedGot, "registryStatus", expected, status)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	for err, expected := range map[error]Status{
+		errors.New("timeout"): StatusError,
+		&rcodeError{rCode: dns.RcodeServerFailure, retry: true}: StatusError,
+		&rcodeError{rCode: dns.RcodeNotImplemented}:             StatusUnknown,
+		ErrDisputed: StatusUnknown,
+	} {
+		if status := errorStatus(err); status != expected {
+			t.Errorf(tests.ErrFmtExpectedGot, "errorStatus", expected, status)
+		}
+	}
+	if !StatusOnHold.Known() || StatusError.Known() || Status(42).String() != "UNKNOWN" {
+		t.Errorf(tests.ErrFmtExpectedGot, "Known", "verdicts only", "unknown and error as verdicts")
+	}
+}
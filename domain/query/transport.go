@@ -0,0 +1,198 @@
+package query
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Defaults for DNS transports
+const (
+	DefaultDNSTimeout = 2 * time.Second
+	DefaultDoHPath    = "/dns-query"
+
+	dohMediaType  = "application/dns-message"
+	maxDoHMsgSize = 65535
+)
+
+// Timeouts of DNS queries: Dial for connecting to servers over tcp, tls or https, and Write and Read for sending a
+// query and waiting for its answer
+type Timeouts struct {
+	Dial, Write, Read time.Duration
+}
+
+// DefaultTimeouts waits DefaultDNSTimeout for everything
+var DefaultTimeouts = Timeouts{DefaultDNSTimeout, DefaultDNSTimeout, DefaultDNSTimeout}
+
+// client returns a dns.Client for network with the timeouts of t
+func (t Timeouts) client(network string) *dns.Client {
+	return &dns.Client{Net: network, DialTimeout: t.Dial, WriteTimeout: t.Write, ReadTimeout: t.Read}
+}
+
+// retryTruncated asks again over TCP for m, which got a truncated answer over UDP, adding up the time both took
+func (t Timeouts) retryTruncated(m *dns.Msg, rtt time.Duration, addr string) (*dns.Msg, time.Duration, error) {
+	tcpIn, tcpRTT, err := t.client("tcp").Exchange(m, addr)
+	return tcpIn, rtt + tcpRTT, err
+}
+
+// Transport sends DNS queries to a server and returns the answer, and how long it took. Proto names the protocol
+// used with server (udp, tcp, tls or https).
+type Transport interface {
+	Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error)
+	Proto(server string) string
+}
+
+// DNSTransport is a Transport speaking plain DNS over udp or tcp, or DNS over TLS (RFC 7858) with tcp-tls. Servers
+// without a port are queried on 53, or 853 for tcp-tls. Truncated UDP answers are asked again over TCP.
+type DNSTransport struct {
+	Net       string
+	TLSConfig *tls.Config
+	Timeouts  Timeouts
+}
+
+// NewDNSTransport returns a DNSTransport for network udp, tcp or tcp-tls
+func NewDNSTransport(network string) *DNSTransport {
+	return &DNSTransport{Net: network, Timeouts: DefaultTimeouts}
+}
+
+// Exchange implements Transport
+func (t *DNSTransport) Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	client := t.Timeouts.client(t.Net)
+	client.TLSConfig = t.TLSConfig
+	port := "53"
+	if t.Net == "tcp-tls" {
+		port = "853"
+	}
+	addr := serverAddr(server, port)
+	// older versions of the dns package return truncated answers with an error
+	in, rtt, err := client.Exchange(m, addr)
+	if in != nil && in.Truncated && t.Net == "udp" {
+		return t.Timeouts.retryTruncated(m, rtt, addr)
+	}
+	return in, rtt, err
+}
+
+// Proto implements Transport
+func (t *DNSTransport) Proto(server string) string {
+	if t.Net == "tcp-tls" {
+		return "tls"
+	}
+	return t.Net
+}
+
+// serverAddr returns the address of server, on port unless it has one
+func serverAddr(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), port)
+}
+
+// DoHTransport is a Transport speaking DNS over HTTPS (RFC 8484), with GET or POST requests. Servers are URLs, or
+// hosts queried at https://host/Path.
+type DoHTransport struct {
+	Client *http.Client
+	Method string
+	Path   string
+}
+
+// SetTimeouts makes requests of t give up after the time of all timeouts, and connections after the Dial one
+func (t *DoHTransport) SetTimeouts(timeouts Timeouts) {
+	t.Client.Timeout = timeouts.Dial + timeouts.Write + timeouts.Read
+	if transport, ok := t.Client.Transport.(*http.Transport); ok {
+		transport.DialContext = (&net.Dialer{Timeout: timeouts.Dial}).DialContext
+		transport.TLSHandshakeTimeout = timeouts.Dial
+	}
+}
+
+// NewDoHTransport returns a DoHTransport making method (GET/POST) requests with tlsConfig
+func NewDoHTransport(method string, tlsConfig *tls.Config) *DoHTransport {
+	return &DoHTransport{
+		Client: &http.Client{
+			Timeout:   DefaultDNSTimeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		},
+		Method: method,
+		Path:   DefaultDoHPath,
+	}
+}
+
+// Exchange implements Transport
+func (t *DoHTransport) Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	// DoH clients should use ID 0 so answers can be cached by HTTP caches
+	query := m.Copy()
+	query.Id = 0
+	wire, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := t.request(wire, t.url(server))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", dohMediaType)
+
+	start := time.Now()
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDoHMsgSize))
+	rtt := time.Since(start)
+	if err != nil {
+		return nil, rtt, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, rtt, fmt.Errorf("DoH server %s answered %s", server, resp.Status)
+	}
+	in := new(dns.Msg)
+	if err := in.Unpack(body); err != nil {
+		return nil, rtt, err
+	}
+	in.Id = m.Id
+	return in, rtt, nil
+}
+
+// Proto implements Transport
+func (t *DoHTransport) Proto(server string) string {
+	return "https"
+}
+
+func (t *DoHTransport) request(wire []byte, url string) (*http.Request, error) {
+	switch strings.ToUpper(t.Method) {
+	case http.MethodGet:
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		return http.NewRequest(http.MethodGet, url+sep+"dns="+base64.RawURLEncoding.EncodeToString(wire), nil)
+	case http.MethodPost:
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(wire))
+		if err == nil {
+			req.Header.Set("Content-Type", dohMediaType)
+		}
+		return req, err
+	}
+	return nil, fmt.Errorf("Invalid DoH method: %q (should be GET or POST)", t.Method)
+}
+
+// url returns the DoH endpoint of server
+func (t *DoHTransport) url(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	if ip := net.ParseIP(server); ip != nil && ip.To4() == nil {
+		server = "[" + server + "]"
+	}
+	return "https://" + server + t.Path
+}
@@ -0,0 +1,183 @@
+package query
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1, and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "GenerateKey", "No Error", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "domainerator test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "CreateCertificate", "No Error", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseCertificate", "No Error", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// startDoTServer serves handler over TLS on a local port and returns its address
+func startDoTServer(t *testing.T, handler dns.Handler, cert tls.Certificate) (string, func()) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Listen", "No Error", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{Listener: l, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return l.Addr().String(), func() { server.Shutdown() }
+}
+
+// dohHandler is a DoH endpoint forwarding queries to the DNS server at addr, and remembering the methods used
+func dohHandler(t *testing.T, addr string, methods *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*methods = append(*methods, r.Method)
+		var wire []byte
+		var err error
+		if r.Method == http.MethodPost {
+			if r.Header.Get("Content-Type") != dohMediaType {
+				t.Errorf(tests.ErrFmtExpectedGot, "Exchange", dohMediaType, r.Header.Get("Content-Type"))
+			}
+			wire, err = ioutil.ReadAll(r.Body)
+		} else {
+			wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		}
+		m := new(dns.Msg)
+		if err == nil {
+			err = m.Unpack(wire)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.Id != 0 {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Exchange", 0, m.Id)
+		}
+		in, err := dns.Exchange(m, addr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		out, _ := in.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(out)
+	})
+}
+
+// exchangeRcode sends a query for name through transport and returns the rcode of the answer
+func exchangeRcode(t *testing.T, transport Transport, server, name string) int {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	in, _, err := transport.Exchange(m, server)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Exchange", "No Error", err)
+	}
+	if in.Id != m.Id {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Exchange", m.Id, in.Id)
+	}
+	return in.Rcode
+}
+
+func TestDNSTransportTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	addr, stop := startDoTServer(t, probeHandler(false, "wild"), cert)
+	defer stop()
+
+	transport := NewDNSTransport("tcp-tls")
+	transport.TLSConfig = &tls.Config{RootCAs: pool}
+	if rcode := exchangeRcode(t, transport, addr, "a.wild."); rcode != dns.RcodeSuccess {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NOERROR", dns.RcodeToString[rcode])
+	}
+	if rcode := exchangeRcode(t, transport, addr, "a.test."); rcode != dns.RcodeNameError {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NXDOMAIN", dns.RcodeToString[rcode])
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.test.", dns.TypeA)
+	if _, _, err := NewDNSTransport("tcp-tls").Exchange(m, addr); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "untrusted certificate error", "No Error")
+	}
+}
+
+func TestDoHTransport(t *testing.T) {
+	addr, stop := startDNSServer(t, probeHandler(false, "wild"))
+	defer stop()
+	cert, pool := testCertificate(t)
+	var methods []string
+	ts := httptest.NewUnstartedServer(dohHandler(t, addr, &methods))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		transport := NewDoHTransport(method, &tls.Config{RootCAs: pool})
+		if rcode := exchangeRcode(t, transport, ts.URL+DefaultDoHPath, "a.wild."); rcode != dns.RcodeSuccess {
+			t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NOERROR", dns.RcodeToString[rcode])
+		}
+		host := ts.Listener.Addr().String()
+		if rcode := exchangeRcode(t, transport, host, "a.test."); rcode != dns.RcodeNameError {
+			t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "NXDOMAIN", dns.RcodeToString[rcode])
+		}
+	}
+	expected := []string{"GET", "GET", "POST", "POST"}
+	if !reflect.DeepEqual(methods, expected) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Exchange", expected, methods)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.test.", dns.TypeA)
+	if _, _, err := NewDoHTransport("PUT", nil).Exchange(m, ts.URL); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "invalid method error", "No Error")
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	for server, expected := range map[string]string{
+		"8.8.8.8":       "8.8.8.8:53",
+		"8.8.8.8:5353":  "8.8.8.8:5353",
+		"2001:db8::1":   "[2001:db8::1]:53",
+		"[2001:db8::1]": "[2001:db8::1]:53",
+		"dns.example":   "dns.example:53",
+		"[::1]:5353":    "[::1]:5353",
+	} {
+		if addr := serverAddr(server, "53"); addr != expected {
+			t.Errorf(tests.ErrFmtExpectedGot, "serverAddr", expected, addr)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Defaults for UDPPool
+const (
+	DefaultUDPSockets = 4
+	DefaultUDPWindow  = 256
+)
+
+// errPoolClosed is returned for queries still waiting when a UDPPool is closed
+var errPoolClosed = errors.New("UDP socket pool closed")
+
+// UDPPool sends DNS queries over a few long-lived UDP sockets shared by every goroutine and server, instead of a
+// socket per query. Answers are matched to queries by server address, message ID and question, so many queries can
+// be in flight on the same socket. Each server has at most Window queries in flight, and others wait for their turn.
+// Sockets are opened on first use, and replaced when they fail.
+type UDPPool struct {
+	Sockets int
+	Window  int
+
+	mu      sync.Mutex
+	sockets []*udpSocket
+	next    int
+	addrs   map[string]*net.UDPAddr
+	windows map[string]chan struct{}
+	closed  bool
+}
+
+// NewUDPPool returns a UDPPool with DefaultUDPSockets and DefaultUDPWindow
+func NewUDPPool() *UDPPool {
+	return &UDPPool{
+		Sockets: DefaultUDPSockets,
+		Window:  DefaultUDPWindow,
+		addrs:   map[string]*net.UDPAddr{},
+		windows: map[string]chan struct{}{},
+	}
+}
+
+// Exchange sends m to addr (host:port), waiting up to the Write timeout to send it and the Read one for the answer
+func (p *UDPPool) Exchange(m *dns.Msg, addr string, timeouts Timeouts) (*dns.Msg, time.Duration, error) {
+	if len(m.Question) != 1 {
+		return nil, 0, fmt.Errorf("Invalid DNS query: %d questions", len(m.Question))
+	}
+	sock, raddr, window, err := p.route(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	window <- struct{}{}
+	defer func() { <-window }()
+	return sock.exchange(m, raddr, timeouts)
+}
+
+// Close closes the sockets of the pool, failing the queries still waiting for answers
+func (p *UDPPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, sock := range p.sockets {
+		sock.conn.Close()
+	}
+	p.sockets = nil
+	return nil
+}
+
+// route returns the next socket, opening a new one in place of a failed one, and the address and window of addr
+func (p *UDPPool) route(addr string) (*udpSocket, *net.UDPAddr, chan struct{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, nil, nil, errPoolClosed
+	}
+	if len(p.sockets) == 0 {
+		if err := p.open(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	raddr, ok := p.addrs[addr]
+	if !ok {
+		var err error
+		if raddr, err = net.ResolveUDPAddr("udp", addr); err != nil {
+			return nil, nil, nil, err
+		}
+		p.addrs[addr] = raddr
+		p.windows[addr] = make(chan struct{}, maxInt(p.Window, 1))
+	}
+	i := p.next % len(p.sockets)
+	p.next++
+	if p.sockets[i].failure() != nil {
+		sock, err := openUDPSocket()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		p.sockets[i].conn.Close()
+		p.sockets[i] = sock
+	}
+	return p.sockets[i], raddr, p.windows[addr], nil
+}
+
+func (p *UDPPool) open() error {
+	for i := 0; i < maxInt(p.Sockets, 1); i++ {
+		sock, err := openUDPSocket()
+		if err != nil {
+			for _, sock := range p.sockets {
+				sock.conn.Close()
+			}
+			p.sockets = nil
+			return err
+		}
+		p.sockets = append(p.sockets, sock)
+	}
+	return nil
+}
+
+// pendingKey identifies a query waiting for its answer on a socket
+type pendingKey struct {
+	addr string
+	id   uint16
+}
+
+type pendingQuery struct {
+	question dns.Question
+	answer   chan *dns.Msg
+}
+
+// udpSocket is a socket of a UDPPool and the queries waiting for answers on it
+type udpSocket struct {
+	conn *net.UDPConn
+	wmu  sync.Mutex // writes, so each has its own deadline
+
+	mu      sync.Mutex
+	pending map[pendingKey]*pendingQuery
+	err     error
+}
+
+// openUDPSocket opens a udpSocket and starts reading answers from it
+func openUDPSocket() (*udpSocket, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	sock := &udpSocket{conn: conn, pending: map[pendingKey]*pendingQuery{}}
+	go sock.read()
+	return sock, nil
+}
+
+func (s *udpSocket) exchange(m *dns.Msg, raddr *net.UDPAddr, timeouts Timeouts) (*dns.Msg, time.Duration, error) {
+	query := m.Copy()
+	key, pq, err := s.register(raddr.String(), query)
+	if err != nil {
+		return nil, 0, err
+	}
+	wire, err := query.Pack()
+	if err != nil {
+		s.unregister(key)
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	if err := s.write(wire, raddr, timeouts.Write); err != nil {
+		s.unregister(key)
+		return nil, 0, err
+	}
+	timeout := timeouts.Read
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case in, ok := <-pq.answer:
+		rtt := time.Since(start)
+		if !ok {
+			return nil, rtt, s.failure()
+		}
+		in.Id = m.Id
+		return in, rtt, nil
+	case <-timer.C:
+		s.unregister(key)
+		return nil, time.Since(start), timeoutError{raddr, timeout}
+	}
+}
+
+// write sends wire to raddr, waiting up to timeout (if set)
+func (s *udpSocket) write(wire []byte, raddr *net.UDPAddr, timeout time.Duration) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if timeout > 0 {
+		if err := s.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	_, err := s.conn.WriteToUDP(wire, raddr)
+	return err
+}
+
+// register picks a message ID not in use with addr for query, and waits for its answer
+func (s *udpSocket) register(addr string, query *dns.Msg) (pendingKey, *pendingQuery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return pendingKey{}, nil, s.err
+	}
+	key := pendingKey{addr, dns.Id()}
+	for s.pending[key] != nil {
+		key.id = dns.Id()
+	}
+	query.Id = key.id
+	pq := &pendingQuery{question: query.Question[0], answer: make(chan *dns.Msg, 1)}
+	s.pending[key] = pq
+	return key, pq, nil
+}
+
+func (s *udpSocket) unregister(key pendingKey) {
+	s.mu.Lock()
+	delete(s.pending, key)
+	s.mu.Unlock()
+}
+
+func (s *udpSocket) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// read delivers answers to the queries waiting for them, until the socket is closed. Answers nobody waits for, or
+// with a different question, are dropped.
+func (s *udpSocket) read() {
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		in := new(dns.Msg)
+		if err := in.Unpack(buf[:n]); (err != nil && !in.Truncated) || len(in.Question) != 1 {
+			continue
+		}
+		key := pendingKey{from.String(), in.Id}
+		s.mu.Lock()
+		pq, ok := s.pending[key]
+		if ok && sameQuestion(pq.question, in.Question[0]) {
+			delete(s.pending, key)
+			pq.answer <- in
+		}
+		s.mu.Unlock()
+	}
+}
+
+// fail wakes up every query waiting on the socket, which can't be used anymore. The UDPPool replaces it.
+func (s *udpSocket) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = errPoolClosed
+	if !strings.Contains(err.Error(), "use of closed network connection") {
+		s.err = err
+	}
+	for key, pq := range s.pending {
+		close(pq.answer)
+		delete(s.pending, key)
+	}
+}
+
+// timeoutError is a net.Error for servers that did not answer in time
+type timeoutError struct {
+	addr    net.Addr
+	timeout time.Duration
+}
+
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("No answer from %s in %s", e.addr, e.timeout)
+}
+
+func sameQuestion(a, b dns.Question) bool {
+	return a.Qtype == b.Qtype && a.Qclass == b.Qclass && strings.EqualFold(a.Name, b.Name)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// UDPTransport is a Transport sending plain DNS queries through a UDPPool. Servers without a port are queried on 53.
+// Queries are sent and answered within the Write and Read timeouts, and truncated answers are asked again over TCP.
+type UDPTransport struct {
+	Pool     *UDPPool
+	Timeouts Timeouts
+}
+
+// NewUDPTransport returns a UDPTransport using pool
+func NewUDPTransport(pool *UDPPool) *UDPTransport {
+	return &UDPTransport{Pool: pool, Timeouts: DefaultTimeouts}
+}
+
+// Exchange implements Transport
+func (t *UDPTransport) Exchange(m *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	addr := serverAddr(server, "53")
+	in, rtt, err := t.Pool.Exchange(m, addr, t.Timeouts)
+	if err == nil && in.Truncated {
+		return t.Timeouts.retryTruncated(m, rtt, addr)
+	}
+	return in, rtt, err
+}
+
+// Proto implements Transport
+func (t *UDPTransport) Proto(server string) string {
+	return "udp"
+}
@@ -0,0 +1,135 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// echoHandler answers every A query with an address for the name asked, except for names under "drop.", which get
+// no answer at all
+func echoHandler(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	if strings.HasSuffix(q.Name, ".drop.") {
+		return
+	}
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	w.WriteMsg(m)
+}
+
+func TestUDPPool(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(echoHandler))
+	defer stop()
+	pool := NewUDPPool()
+	pool.Sockets, pool.Window = 2, 8
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("n%d.test.", i)
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			in, _, err := pool.Exchange(m, addr, Timeouts{Write: time.Second, Read: time.Second})
+			switch {
+			case err != nil:
+				errs <- err
+			case in.Id != m.Id || len(in.Answer) != 1 || in.Answer[0].Header().Name != name:
+				errs <- fmt.Errorf("wrong answer for %s: %s", name, in)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(pool.sockets) != 2 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "sockets", 2, len(pool.sockets))
+	}
+}
+
+func TestUDPPoolTimeoutAndClose(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(echoHandler))
+	defer stop()
+	transport := NewUDPTransport(NewUDPPool())
+	transport.Timeouts.Read = 100 * time.Millisecond
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.drop.", dns.TypeA)
+	if _, _, err := transport.Exchange(m, addr); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "Exchange", "timeout error", "No Error")
+	}
+	if n := len(transport.Pool.sockets[0].pending); n != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "pending queries", 0, n)
+	}
+
+	transport.Pool.Close()
+	m.SetQuestion("a.test.", dns.TypeA)
+	if _, _, err := transport.Exchange(m, addr); err != errPoolClosed {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Exchange", errPoolClosed, err)
+	}
+}
+
+func TestUDPPoolReplacesFailedSockets(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(echoHandler))
+	defer stop()
+	transport := NewUDPTransport(NewUDPPool())
+	transport.Pool.Sockets = 1
+	defer transport.Pool.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.test.", dns.TypeA)
+	if _, _, err := transport.Exchange(m, addr); err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGotV, "Exchange", "No Error", err)
+	}
+	failed := transport.Pool.sockets[0]
+	failed.fail(errors.New("read: connection refused"))
+	if _, _, err := transport.Exchange(m, addr); err != nil {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Exchange", "No Error", err)
+	}
+	if transport.Pool.sockets[0] == failed {
+		t.Errorf(tests.ErrFmtExpectedGotV, "sockets", "failed socket replaced", "same socket")
+	}
+}
+
+func benchmarkTransport(b *testing.B, transport Transport) {
+	addr, stop := startDNSServer(b, dns.HandlerFunc(echoHandler))
+	defer stop()
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		m := new(dns.Msg)
+		m.SetQuestion("bench.test.", dns.TypeA)
+		for pb.Next() {
+			if _, _, err := transport.Exchange(m, addr); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func BenchmarkUDPPool(b *testing.B) {
+	pool := NewUDPPool()
+	defer pool.Close()
+	benchmarkTransport(b, NewUDPTransport(pool))
+}
+
+func BenchmarkDNSTransport(b *testing.B) {
+	benchmarkTransport(b, NewDNSTransport("udp"))
+}
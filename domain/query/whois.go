@@ -0,0 +1,187 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultWHOISQuery    = "%s\r\n"
+	defaultWHOISInterval = time.Second
+)
+
+// WHOISServer describes how to query the WHOIS server of a TLD
+type WHOISServer struct {
+	Server   string `json:"server"`   // host or host:port (port 43 if missing)
+	Query    string `json:"query"`    // fmt format of the query, receives the domain
+	NotFound string `json:"notFound"` // regular expression matching answers of available domains
+	Reserved string `json:"reserved"` // optional regular expression matching answers of reserved domains
+	Premium  string `json:"premium"`  // optional regular expression matching answers of premium domains
+	Interval string `json:"interval"` // minimum time between queries to Server, like "500ms"
+
+	notFound *regexp.Regexp
+	reserved *regexp.Regexp
+	premium  *regexp.Regexp
+	interval time.Duration
+}
+
+// WHOISConfig maps TLDs to their WHOIS servers
+type WHOISConfig map[string]*WHOISServer
+
+// LoadWHOISConfig reads a WHOIS config file from disk
+func LoadWHOISConfig(path string) (WHOISConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseWHOISConfig(content)
+}
+
+// ParseWHOISConfig parses a JSON object mapping TLDs to WHOISServer entries
+func ParseWHOISConfig(content []byte) (WHOISConfig, error) {
+	config := WHOISConfig{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("Invalid WHOIS config file: %s", err)
+	}
+	for tld, entry := range config {
+		if entry == nil || entry.Server == "" || entry.NotFound == "" {
+			return nil, fmt.Errorf("WHOIS entry for %q needs a server and a notFound pattern", tld)
+		}
+		if _, _, err := net.SplitHostPort(entry.Server); err != nil {
+			entry.Server = net.JoinHostPort(entry.Server, "43")
+		}
+		if entry.Query == "" {
+			entry.Query = defaultWHOISQuery
+		}
+		re, err := regexp.Compile(entry.NotFound)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid notFound pattern for %q: %s", tld, err)
+		}
+		entry.notFound = re
+		if entry.reserved, err = compileOptional(entry.Reserved); err != nil {
+			return nil, fmt.Errorf("Invalid reserved pattern for %q: %s", tld, err)
+		}
+		if entry.premium, err = compileOptional(entry.Premium); err != nil {
+			return nil, fmt.Errorf("Invalid premium pattern for %q: %s", tld, err)
+		}
+		entry.interval = defaultWHOISInterval
+		if entry.Interval != "" {
+			if entry.interval, err = time.ParseDuration(entry.Interval); err != nil {
+				return nil, fmt.Errorf("Invalid interval for %q: %s", tld, err)
+			}
+		}
+		if tld != strings.ToLower(tld) {
+			delete(config, tld)
+			config[strings.ToLower(tld)] = entry
+		}
+	}
+	return config, nil
+}
+
+// compileOptional compiles expr, unless it is empty
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
+
+// lookup returns the WHOISServer responsible for domain
+func (c WHOISConfig) lookup(domain string) (*WHOISServer, bool) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	for i := 1; i < len(labels); i++ {
+		if entry, ok := c[strings.Join(labels[i:], ".")]; ok {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// throttle spaces out calls sharing the same key by a minimum interval
+type throttle struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{next: map[string]time.Time{}}
+}
+
+// wait blocks until a call to key is allowed or ctx is done
+func (t *throttle) wait(ctx context.Context, key string, interval time.Duration) error {
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.next[key]
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next[key] = slot.Add(interval)
+	t.mu.Unlock()
+	return sleep(ctx, slot.Sub(now))
+}
+
+// WHOISChecker is a Checker that queries the WHOIS server (port 43) of the domain TLD. Domains whose answer matches
+// the notFound pattern are reported as NXDOMAIN so they can be handled like a DNS Result.
+type WHOISChecker struct {
+	Config  WHOISConfig
+	Timeout time.Duration
+	Retry   RetryPolicy
+
+	throttle *throttle
+}
+
+// NewWHOISChecker returns a WHOISChecker using config to find WHOIS servers
+func NewWHOISChecker(config WHOISConfig) *WHOISChecker {
+	return &WHOISChecker{Config: config, Timeout: 10 * time.Second, Retry: DefaultRetryPolicy, throttle: newThrottle()}
+}
+
+// Check implements Checker
+func (c *WHOISChecker) Check(ctx context.Context, domain string) Result {
+	entry, ok := c.Config.lookup(domain)
+	if !ok {
+		return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: ErrUnsupported}
+	}
+	return c.Retry.Do(ctx, func(attempt int) Result {
+		if err := c.throttle.wait(ctx, entry.Server, entry.interval); err != nil {
+			return Result{Domain: domain, Rcode: dns.RcodeServerFailure, Err: err}
+		}
+		start := time.Now()
+		answer, err := c.query(ctx, entry, domain)
+		r := Result{Domain: domain, Status: StatusRegistered, Rcode: dns.RcodeSuccess, Err: err,
+			Server: entry.Server, Transport: "whois", RTT: time.Since(start)}
+		switch {
+		case err != nil:
+			r.Rcode = dns.RcodeServerFailure
+		case entry.reserved != nil && entry.reserved.Match(answer):
+			r.Status = StatusReserved
+		case entry.premium != nil && entry.premium.Match(answer):
+			r.Rcode, r.Status = dns.RcodeNameError, StatusPremium
+		case entry.notFound.Match(answer):
+			r.Rcode, r.Status = dns.RcodeNameError, StatusAvailable
+		}
+		return r
+	})
+}
+
+func (c *WHOISChecker) query(ctx context.Context, entry *WHOISServer, domain string) ([]byte, error) {
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", entry.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.Timeout))
+	if _, err := fmt.Fprintf(conn, entry.Query, strings.ToLower(domain)); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(conn)
+}
@@ -0,0 +1,108 @@
+package query
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hgfischer/domainerator/tests"
+)
+
+// startWHOISServer starts a WHOIS stand-in answering "No match" for domains starting with "free", and flagging the
+// ones starting with "nic" and "gold" as reserved and premium
+func startWHOISServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "net.Listen", "No Error", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				domain := strings.TrimSpace(strings.TrimPrefix(line, "domain "))
+				switch {
+				case strings.HasPrefix(domain, "free"):
+					fmt.Fprintf(conn, "%% No match for %s\r\n", domain)
+				case strings.HasPrefix(domain, "nic"):
+					fmt.Fprintf(conn, "%% %s is reserved by the registry\r\n", domain)
+				case strings.HasPrefix(domain, "gold"):
+					fmt.Fprintf(conn, "%% No match for %s\r\n%% Premium name\r\n", domain)
+				default:
+					fmt.Fprintf(conn, "Domain: %s\r\nStatus: active\r\n", domain)
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func newWHOISTestChecker(t *testing.T, server, interval string) *WHOISChecker {
+	content := fmt.Sprintf(`{"li":{"server":%q,"query":"domain %%s\n","notFound":"(?m)^%% No match",
+		"reserved":"is reserved","premium":"Premium name","interval":%q}}`, server, interval)
+	config, err := ParseWHOISConfig([]byte(content))
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseWHOISConfig", "No Error", err)
+	}
+	return NewWHOISChecker(config)
+}
+
+func TestParseWHOISConfig(t *testing.T) {
+	config, err := ParseWHOISConfig([]byte(`{"LI":{"server":"whois.nic.ch","notFound":"not found"}}`))
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ParseWHOISConfig", "No Error", err)
+	}
+	entry, ok := config.lookup("example.li")
+	if !ok {
+		t.Fatalf(tests.ErrFmtExpectedGotV, "lookup", true, ok)
+	}
+	if entry.Server != "whois.nic.ch:43" || entry.Query != defaultWHOISQuery || entry.interval != defaultWHOISInterval {
+		t.Errorf(tests.ErrFmtExpectedGotV, "ParseWHOISConfig", "defaults", entry)
+	}
+	if _, err := ParseWHOISConfig([]byte(`{"li":{"server":"whois.nic.ch","notFound":"("}}`)); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "ParseWHOISConfig", "Invalid pattern error", "No Error")
+	}
+}
+
+func TestWHOISChecker(t *testing.T) {
+	l := startWHOISServer(t)
+	defer l.Close()
+	checker := newWHOISTestChecker(t, l.Addr().String(), "1ms")
+
+	if r := checker.Check(context.Background(), "free.li"); r.Err != nil || !r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "WHOISChecker.Check", "available", r)
+	}
+	if r := checker.Check(context.Background(), "taken.li"); r.Err != nil || r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGotV, "WHOISChecker.Check", "taken", r)
+	}
+	for domain, status := range map[string]Status{"nic.li": StatusReserved, "gold.li": StatusPremium} {
+		if r := checker.Check(context.Background(), domain); r.Status != status {
+			t.Errorf(tests.ErrFmtExpectedGot, "WHOISChecker.Check", status, r.Status)
+		}
+	}
+	if r := checker.Check(context.Background(), "free.wf"); r.Err != ErrUnsupported {
+		t.Errorf(tests.ErrFmtExpectedGotV, "WHOISChecker.Check", ErrUnsupported, r.Err)
+	}
+}
+
+func TestWHOISCheckerRateLimit(t *testing.T) {
+	l := startWHOISServer(t)
+	defer l.Close()
+	checker := newWHOISTestChecker(t, l.Addr().String(), "50ms")
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		checker.Check(context.Background(), "free.li")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf(tests.ErrFmtExpectedGotV, "WHOISChecker.Check", ">= 100ms", elapsed)
+	}
+}
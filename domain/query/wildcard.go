@@ -0,0 +1,81 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// wildcardProbers is how many suffixes are probed at once by FindWildcards
+const wildcardProbers = 10
+
+// FindWildcards asks about random names under each of suffixes and returns the ones where most of them exist, which
+// means the suffix has wildcard records and NS answers can't tell registered domains apart.
+func (c *NSChecker) FindWildcards(ctx context.Context, suffixes []string, probes int) []string {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		wildcards []string
+		slots     = make(chan struct{}, wildcardProbers)
+	)
+	for _, suffix := range suffixes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(suffix string) {
+			defer func() { <-slots; wg.Done() }()
+			if c.hasWildcard(ctx, suffix, probes) {
+				mu.Lock()
+				wildcards = append(wildcards, suffix)
+				mu.Unlock()
+			}
+		}(suffix)
+	}
+	wg.Wait()
+	return wildcards
+}
+
+func (c *NSChecker) hasWildcard(ctx context.Context, suffix string, probes int) bool {
+	exist, answered := 0, 0
+	for i := 0; i < probes; i++ {
+		server := c.Servers.Pick()
+		if err := c.Limits.Wait(ctx, server); err != nil {
+			c.Servers.Release(server)
+			return false
+		}
+		in, rtt, err := c.query(randomLabel()+"."+suffix, dns.TypeA, server)
+		c.Servers.Report(server, rtt, retriable(err))
+		if err != nil || (in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError) {
+			continue
+		}
+		answered++
+		if in.Rcode == dns.RcodeSuccess {
+			exist++
+		}
+	}
+	return answered > 0 && exist*2 > answered
+}
+
+// wildcardSuffix tells if domain is right below one of the Wildcards suffixes
+func (c *NSChecker) wildcardSuffix(domain string) bool {
+	parts := strings.SplitN(strings.TrimSuffix(domain, "."), ".", 2)
+	return len(parts) == 2 && c.Wildcards[parts[1]]
+}
+
+// soaRcode classifies a SOA answer for domain under a wildcard suffix. A registered domain is a zone of its own, so
+// its SOA is owned by domain itself. Anything else is the wildcard of the parent zone speaking, and is taken as
+// NXDOMAIN.
+func soaRcode(domain string, in *dns.Msg) int {
+	if in.Rcode != dns.RcodeSuccess {
+		return in.Rcode
+	}
+	for _, section := range [][]dns.RR{in.Answer, in.Ns} {
+		for _, rr := range section {
+			if soa, ok := rr.(*dns.SOA); ok && equalNames(soa.Hdr.Name, domain) {
+				return dns.RcodeSuccess
+			}
+		}
+	}
+	return dns.RcodeNameError
+}
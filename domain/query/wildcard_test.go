@@ -0,0 +1,68 @@
+package query
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// wildcardHandler serves a "wild" zone with a wildcard A record and a single delegated domain, taken.wild. Names
+// anywhere else don't exist.
+func wildcardHandler(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	switch {
+	case !strings.HasSuffix(q.Name, ".wild."):
+		m.Rcode = dns.RcodeNameError
+	case q.Qtype == dns.TypeSOA && q.Name == "taken.wild.":
+		m.Answer = append(m.Answer, mustRR("taken.wild. 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+	case q.Qtype == dns.TypeA:
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+	default:
+		m.Ns = append(m.Ns, mustRR("wild. 3600 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+	}
+	w.WriteMsg(m)
+}
+
+func TestFindWildcards(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(wildcardHandler))
+	defer stop()
+	c := NewNSChecker([]string{addr}, NewDNSTransport("udp"))
+
+	wildcards := c.FindWildcards(context.Background(), []string{"com", "wild", "org"}, 3)
+	if !reflect.DeepEqual(wildcards, []string{"wild"}) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "FindWildcards", []string{"wild"}, wildcards)
+	}
+}
+
+func TestNSCheckerWildcardSuffix(t *testing.T) {
+	addr, stop := startDNSServer(t, dns.HandlerFunc(wildcardHandler))
+	defer stop()
+	c := NewNSChecker([]string{addr}, NewDNSTransport("udp"))
+
+	// NS answers take everything under a wildcard
+	if r := c.Check(context.Background(), "free.wild"); r.Available() {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "free.wild taken without Wildcards", r.String(false))
+	}
+
+	c.Wildcards = map[string]bool{"wild": true}
+	for domain, available := range map[string]bool{"free.wild": true, "taken.wild": false, "free.com": true} {
+		if r := c.Check(context.Background(), domain); r.Available() != available || r.Unknown() {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Check", domain, r.String(false))
+		}
+	}
+
+	r := c.Check(context.Background(), "taken.wild")
+	if r.Server != addr || r.Transport != "udp" || r.Attempts != 1 || r.SOA == nil || r.SOA.Hdr.Name != "taken.wild." {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", "server, transport, attempts and SOA", r.String(false))
+	}
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/hgfischer/domainerator/domain/name"
-	"github.com/hgfischer/domainerator/domain/ns"
 	"github.com/hgfischer/domainerator/domain/query"
+	"github.com/hgfischer/domainerator/journal"
+	"github.com/hgfischer/domainerator/pipeline"
 	"github.com/hgfischer/domainerator/wordlist"
+	"github.com/miekg/dns"
 )
 
 const (
@@ -30,13 +39,52 @@ var (
 	includeTLDs = flag.Bool("tlds", false, "Include all TLDs in public domain suffix list")
 	includeUTF8 = flag.Bool("utf8", false, "Include combinations with UTF-8 characters")
 	publicCSV   = flag.String("ps", defaultPublicSuffixes, "Public domain suffixes to combine with")
-	dnsCSV      = flag.String("dns", defaultDNSServers, "Comma-separated list of DNS servers to talk to")
-	protocol    = flag.String("proto", "udp", "Protocol (udp/tcp)")
+	dnsCSV      = flag.String("dns", defaultDNSServers, "Comma-separated DNS servers to talk to (empty: /etc/resolv.conf)")
+	protocol    = flag.String("proto", "udp", "Protocol of DNS servers without scheme (udp/tcp/tls/https)")
+	dohMethod   = flag.String("doh-method", "GET", "HTTP method of DNS over HTTPS queries (GET/POST)")
+	tlsName     = flag.String("tls-name", "", "Server name expected in TLS certificates (default: the DNS server)")
+	tlsCA       = flag.String("tls-ca", "", "PEM file with the CA certificates trusted for TLS (default: system CAs)")
+	tlsInsecure = flag.Bool("tls-insecure", false, "Do not verify TLS certificates of DNS servers")
 	maxLength   = flag.Int("maxlen", 64, "Maximum length of generated domains including public suffix")
 	minLength   = flag.Int("minlen", 3, "Minimum length of generated domains without public suffic")
-	concurrency = flag.Int("c", 50, "Number of concurrent threads doing checks")
-	available   = flag.Bool("avail", true, "If true, output only available domains (NXDOMAIN) without DNS status code")
+	concurrency = flag.Int("c", 50, "Maximum number of concurrent checks, adapted at runtime to avoid timeouts")
+	available   = flag.Bool("avail", true, "If true, output only AVAILABLE domains, without status and details")
 	strictMode  = flag.Bool("strict", true, "If true, filter some possibly prohibited domains (domain == tld, etc)")
+	retries     = flag.Int("retries", 4, "Maximum number of attempts for each domain before giving up as unknown")
+	backoff     = flag.Duration("backoff", 250*time.Millisecond, "Base delay between attempts, doubled on each retry")
+	rcodes      = flag.String("rcodes", "", "RCODE=action pairs (retry/available/taken/unknown), ex.: SERVFAIL=retry")
+	maxFailures = flag.Float64("maxfail", query.DefaultMaxFailureRate, "Failure rate that ejects a DNS server")
+	cooldown    = flag.Duration("cooldown", query.DefaultCooldown, "Time before probing an ejected DNS server")
+	serverQPS   = flag.Float64("qps", 50, "Maximum queries per second to each DNS server (0 = unlimited)")
+	globalQPS   = flag.Float64("gqps", 0, "Maximum queries per second to all DNS servers together (0 = unlimited)")
+	resume      = flag.Bool("resume", false, "Resume an interrupted run, checking only new, UNKNOWN and ERROR domains")
+	journalPath = flag.String("journal", "", "Journal of checked domains (default: output file + \".journal\")")
+	cachePath   = flag.String("cache", "", "File caching results between runs (disabled if empty)")
+	takenTTL    = flag.Duration("cache-taken", query.DefaultRegisteredTTL, "How long registered domains stay cached")
+	availTTL    = flag.Duration("cache-avail", query.DefaultAvailableTTL, "How long available domains stay cached")
+	refresh     = flag.Bool("refresh", false, "Check every domain again, ignoring cached results")
+	dnssec      = flag.Bool("dnssec", false, "Validate NXDOMAIN answers with DNSSEC and mark proven available domains")
+	anchorsFile = flag.String("anchors", "", "File with DS records of the root zone (default: built-in IANA anchors)")
+	rdapFile    = flag.String("rdap", "", "IANA RDAP bootstrap file (dns.json) used to confirm available domains")
+	whoisFile   = flag.String("whois", "", "WHOIS config file (JSON) used to confirm available domains")
+	hijackTest  = flag.Bool("hijack", true, "Drop DNS servers answering for non-existent domains (NXDOMAIN hijacking)")
+	probes      = flag.Int("probes", 2, "Random names per TLD sent to each DNS server when looking for hijacking")
+	voters      = flag.Int("voters", 0, "Ask each domain of this many distinct DNS servers (0 = ask only one)")
+	quorum      = flag.Int("quorum", 0, "Servers that must agree on a domain, or it is DISPUTED (default: majority)")
+	iterative   = flag.Bool("iterative", false, "Ask TLD authoritative servers directly instead of the -dns resolvers")
+	rootsFile   = flag.String("roots", "", "Root hints file (named.root) for -iterative (default: built-in root servers)")
+	ipv6        = flag.Bool("ipv6", false, "Also query authoritative servers over IPv6 with -iterative")
+	wildcards   = flag.Bool("wildcards", true, "Look for public suffixes with wildcards and check them by SOA owner")
+	sockets     = flag.Int("sockets", query.DefaultUDPSockets, "Number of UDP sockets shared by all queries")
+	window      = flag.Int("window", query.DefaultUDPWindow, "Maximum UDP queries in flight to each DNS server")
+	dialTimeout = flag.Duration("dial-timeout", query.DefaultDNSTimeout, "Time to wait for tcp/tls/https connections")
+	sendTimeout = flag.Duration("write-timeout", query.DefaultDNSTimeout, "Time to wait for a DNS query to be sent")
+	readTimeout = flag.Duration("read-timeout", query.DefaultDNSTimeout, "Time to wait for the answer of a DNS query")
+	ednsSize    = flag.Uint("edns-size", query.DefaultEDNSSize, "EDNS0 UDP payload size for DNS answers (0 = no EDNS0)")
+	cookies     = flag.Bool("cookies", false, "Send DNS cookies to DNS servers")
+	subnet      = flag.String("subnet", "", "EDNS0 client subnet sent to DNS servers, like 192.0.2.0/24 (default: none)")
+	probe       = flag.Bool("probe", false, "Show which domains are delegated, parked, undelegated or absent")
+	dedupMemory = flag.Int("dedup-mb", 64, "Megabytes used to find duplicate domains while generating them")
 )
 
 // Prints an error message to stderr and exist with a return code
@@ -67,7 +115,7 @@ func loadWordLists(prefixFile, suffixFile string) (prefixes, suffixes []string)
 	prefixes = loadWordList(prefixFile)
 	suffixes = loadWordList(suffixFile)
 	if len(prefixes) == 0 && len(suffixes) == 0 {
-		showErrorAndExit(errors.New("Empty wordlists"), 12)
+		showErrorAndExit(pipeline.ErrEmptyWordLists, 12)
 	}
 	fmt.Println("done.")
 	return
@@ -83,19 +131,24 @@ func loadFlags() {
 }
 
 func loadPublicSuffixList() (psl []string) {
-	psl, err := name.ParsePublicSuffixCSV(*publicCSV, ns.PublicSuffixes, *includeTLDs)
+	psl, err := pipeline.PublicSuffixes(*publicCSV, *includeTLDs, *includeUTF8)
 	if err != nil {
 		showErrorAndExit(err, 20)
 	}
-	if !*includeUTF8 {
-		psl = wordlist.FilterUTF8(psl)
-	}
 	fmt.Printf("Public Suffixes: %s\n", strings.Join(psl, ", "))
 	return
 }
 
-func loadDNSServers() (dnsServers []string) {
-	dnsServers = name.ParseDNSCSV(*dnsCSV)
+func loadDNSServers() (dnsServers []*query.Server) {
+	var err error
+	if strings.TrimSpace(*dnsCSV) == "" {
+		dnsServers, err = query.ResolvConfServers(query.ResolvConf, *protocol)
+	} else {
+		dnsServers, err = query.ParseServers(*dnsCSV, *protocol)
+	}
+	if err != nil {
+		showErrorAndExit(err, 30)
+	}
 	if len(dnsServers) == 0 {
 		showErrorAndExit(errors.New("You need to specify a DNS server"), 30)
 	}
@@ -103,93 +156,280 @@ func loadDNSServers() (dnsServers []string) {
 }
 
 func checkProtocol() {
-	if *protocol != "tcp" && *protocol != "udp" {
-		errmsg := fmt.Sprintf("Unknown protocol: %q (should be \"udp\" or \"tcp\"", *protocol)
+	switch *protocol {
+	case "udp", "tcp", "tls", "https":
+	default:
+		errmsg := fmt.Sprintf("Unknown protocol: %q (should be \"udp\", \"tcp\", \"tls\" or \"https\")", *protocol)
 		showErrorAndExit(errors.New(errmsg), 35)
 	}
+	if *protocol == "https" && *dohMethod != http.MethodGet && *dohMethod != http.MethodPost {
+		errmsg := fmt.Sprintf("Unknown DNS over HTTPS method: %q (should be \"GET\" or \"POST\")", *dohMethod)
+		showErrorAndExit(errors.New(errmsg), 35)
+	}
+}
+
+func setupTLS() *tls.Config {
+	config := &tls.Config{ServerName: *tlsName, InsecureSkipVerify: *tlsInsecure}
+	if *tlsCA != "" {
+		pem, err := ioutil.ReadFile(*tlsCA)
+		if err != nil {
+			showErrorAndExit(err, 33)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			showErrorAndExit(fmt.Errorf("No certificates found in %s", *tlsCA), 33)
+		}
+	}
+	return config
+}
+
+func loadTrustAnchors() []*dns.DS {
+	if *anchorsFile == "" {
+		return nil
+	}
+	anchors, err := query.LoadTrustAnchors(*anchorsFile)
+	if err != nil {
+		showErrorAndExit(err, 34)
+	}
+	return anchors
+}
+
+func loadRootHints() []string {
+	if *rootsFile == "" {
+		return nil
+	}
+	roots, err := query.LoadRootHints(*rootsFile, *ipv6)
+	if err != nil {
+		showErrorAndExit(err, 42)
+	}
+	return roots
+}
+
+// Load the RDAP bootstrap and WHOIS config files used to confirm available domains
+func loadConfirmation(options *pipeline.Options) {
+	var err error
+	if *rdapFile != "" {
+		if options.RDAP, err = query.LoadRDAPBootstrap(*rdapFile); err != nil {
+			showErrorAndExit(err, 36)
+		}
+	}
+	if *whoisFile != "" {
+		if options.WHOIS, err = query.LoadWHOISConfig(*whoisFile); err != nil {
+			showErrorAndExit(err, 37)
+		}
+	}
+}
+
+func setupCache() *query.Cache {
+	if *cachePath == "" {
+		return nil
+	}
+	cache, err := query.OpenCache(*cachePath)
+	if err != nil {
+		showErrorAndExit(err, 39)
+	}
+	cache.RegisteredTTL = *takenTTL
+	cache.AvailableTTL = *availTTL
+	fmt.Printf("Cached results: %d\n", cache.Len())
+	return cache
+}
+
+// Turn command line options into pipeline options
+func setupOptions() pipeline.Options {
+	options := pipeline.Options{
+		Single:         *single,
+		Itself:         *itself,
+		Hyphenate:      *hyphenate,
+		Hacks:          *hacks,
+		Fuse:           *fuse,
+		UTF8:           *includeUTF8,
+		Strict:         *strictMode,
+		MinLength:      *minLength,
+		MaxLength:      *maxLength,
+		DedupMemory:    *dedupMemory << 20,
+		Protocol:       *protocol,
+		DoHMethod:      *dohMethod,
+		Timeouts:       query.Timeouts{Dial: *dialTimeout, Write: *sendTimeout, Read: *readTimeout},
+		Sockets:        *sockets,
+		Window:         *window,
+		Cookies:        *cookies,
+		Retries:        *retries,
+		Backoff:        *backoff,
+		MaxFailureRate: *maxFailures,
+		Cooldown:       *cooldown,
+		ServerQPS:      *serverQPS,
+		GlobalQPS:      *globalQPS,
+		HijackTest:     *hijackTest,
+		Probes:         *probes,
+		Wildcards:      *wildcards,
+		Probe:          *probe,
+		DNSSEC:         *dnssec,
+		TrustAnchors:   loadTrustAnchors(),
+		Voters:         *voters,
+		Quorum:         *quorum,
+		Iterative:      *iterative,
+		IPv6:           *ipv6,
+		Refresh:        *refresh,
+		Concurrency:    *concurrency,
+		Log:            os.Stdout,
+	}
+	var err error
+	if options.Rcodes, err = query.ParseRcodePolicy(*rcodes); err != nil {
+		showErrorAndExit(err, 38)
+	}
+	if *ednsSize > 65535 {
+		showErrorAndExit(fmt.Errorf("Invalid EDNS0 UDP payload size: %d", *ednsSize), 43)
+	}
+	options.EDNSSize = uint16(*ednsSize)
+	if *subnet != "" {
+		if options.Subnet, err = query.ParseClientSubnet(*subnet); err != nil {
+			showErrorAndExit(err, 43)
+		}
+	}
+	if *iterative {
+		options.Roots = loadRootHints()
+	} else {
+		options.Servers = loadDNSServers()
+		options.TLSConfig = setupTLS()
+	}
+	loadConfirmation(&options)
+	return options
 }
 
-func setupOutputFile(outputPath string) (outputFile *os.File) {
-	outputFile, err := os.Create(outputPath)
+func setupOutputFile(outputPath string, j *journal.Journal) (outputFile *os.File) {
+	var err error
+	if *resume {
+		if err = pipeline.PruneOutput(outputPath, j.Done); err != nil {
+			showErrorAndExit(err, 40)
+		}
+		outputFile, err = os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	} else {
+		outputFile, err = os.Create(outputPath)
+	}
 	if err != nil {
 		showErrorAndExit(err, 40)
 	}
 	return
 }
 
-func createDomainList(prefixes, suffixes, psl []string) (domains []string) {
-	fmt.Print("Creating domain list... ")
-	domains = name.Combine(prefixes, suffixes, psl, *single, *hyphenate, *itself, *hacks, *fuse, *minLength)
-	if !*includeUTF8 {
-		domains = wordlist.FilterUTF8(domains)
+func setupJournal(outputPath string) (j *journal.Journal) {
+	path := *journalPath
+	if path == "" {
+		path = outputPath + ".journal"
 	}
-	domains = name.FilterMaxLength(domains, *maxLength)
-	if *strictMode {
-		domains = name.FilterStrictDomains(domains, ns.PublicSuffixes)
+	var err error
+	if *resume {
+		j, err = journal.Open(path)
+	} else {
+		j, err = journal.Create(path)
 	}
-	domains = wordlist.RemoveDuplicates(domains)
-	fmt.Println("done.")
-	if len(domains) == 0 {
-		showErrorAndExit(errors.New("I could not generate a single valid domain"), 50)
+	if err != nil {
+		showErrorAndExit(err, 41)
 	}
 	return
 }
 
-func printFeedback(startTime time.Time, processed, total int) {
-	fmtStr := "\rChecked %d of %d domains. Elapsed %s. ETA %s. Goroutines: %d\033[K"
-	elapsed := time.Since(startTime)
-	etaSecs := elapsed.Seconds() * float64(total) / float64(processed)
-	eta := time.Duration(etaSecs) * time.Second
-	out := fmt.Sprintf(fmtStr, processed, total, elapsed, eta, runtime.NumGoroutine())
+func createDomainStream(g *pipeline.Generator, prefixes, suffixes []string, j *journal.Journal) *pipeline.Stream {
+	// domains recorded while checking are skipped too, so counting them after an interrupt leaves out checked ones
+	domains, err := g.Stream(prefixes, suffixes, j.Done)
+	if err != nil {
+		showErrorAndExit(err, 50)
+	}
+	if j.Len() > 0 {
+		fmt.Printf("Resuming: %d domains were already checked.\n", j.Len())
+	}
+	return domains
+}
+
+func printFeedback(stats pipeline.Stats, checker *pipeline.Checker) {
+	fmtStr := "\rChecked %d of %s domains. Elapsed %s. ETA %s. Concurrency: %d/%d. Goroutines: %d. " +
+		"Ejected DNS servers: %d\033[K"
+	elapsed := time.Since(stats.Started)
+	total, eta := "?", "?" // until every domain was generated
+	if stats.Total >= 0 {
+		etaSecs := elapsed.Seconds() * float64(stats.Total) / float64(stats.Checked)
+		total, eta = strconv.Itoa(stats.Total), (time.Duration(etaSecs) * time.Second).String()
+	}
+	ejected := len(checker.Servers.Ejected())
+	out := fmt.Sprintf(fmtStr, stats.Checked, total, elapsed, eta, checker.Adaptive.Limit(), checker.Adaptive.Max,
+		runtime.NumGoroutine(), ejected)
 	fmt.Print(out)
 }
 
-func saveDomainResult(outputFile *os.File, r query.Result, available bool) {
-	if (available && r.Available()) || !available {
-		_, err := outputFile.WriteString(r.String(available))
-		if err != nil {
-			showErrorAndExit(err, 6)
+func printServerSummary(servers *query.ServerPool) {
+	stats := servers.Stats()
+	if len(stats) == 0 {
+		return
+	}
+	fmt.Println("DNS servers:")
+	for _, s := range stats {
+		state := "ok"
+		if s.Ejected {
+			state = "ejected"
 		}
+		fmt.Printf("  %-20s %-8s %6d ok %6d failed %3d ejections, latency %s\n",
+			s.Server, state, s.Successes, s.Failures, s.Ejections, s.Latency)
 	}
 }
 
+// Cancel the returned context on SIGINT/SIGTERM, so checks can drain. A second signal exits right away.
+func setupSignals() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Print("\nInterrupted, waiting for running checks (interrupt again to quit now)...\n")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+	return ctx
+}
+
 // MAIN
 func main() {
 	loadFlags()
 	prefixes, suffixes := loadWordLists(flag.Arg(0), flag.Arg(1))
 	psl := loadPublicSuffixList()
-	dnsServers := loadDNSServers()
 	checkProtocol()
-	outputFile := setupOutputFile(flag.Arg(2))
+	options := setupOptions()
+	options.Cache = setupCache()
+	if options.Cache != nil {
+		defer options.Cache.Close()
+	}
+	checker, err := pipeline.NewChecker(context.Background(), psl, options)
+	if err != nil {
+		showErrorAndExit(err, 30)
+	}
+	defer checker.Close()
+	checked := setupJournal(flag.Arg(2))
+	defer checked.Close()
+	outputFile := setupOutputFile(flag.Arg(2), checked)
 	defer outputFile.Close()
-	domains := createDomainList(prefixes, suffixes, psl)
-	pending, retries, complete := make(chan string), make(chan string), make(chan query.Result)
+	domains := createDomainStream(pipeline.NewGenerator(psl, options), prefixes, suffixes, checked)
+	ctx := setupSignals()
 
 	fmt.Println("Starting checks... ")
-	startTime := time.Now()
-
-	// start checks
-	for i := 0; i < *concurrency; i++ {
-		go query.CheckDomains(i, pending, retries, complete, dnsServers, *protocol)
+	sink := &pipeline.JournalSink{Sink: &pipeline.WriterSink{W: outputFile, Available: *available}, Journal: checked}
+	stats, err := checker.Run(ctx, domains, sink, func(stats pipeline.Stats) { printFeedback(stats, checker) })
+	if err != nil {
+		showErrorAndExit(err, 6)
 	}
-
-	// send domains
-	go func() {
-		for _, domain := range domains {
-			pending <- domain
-		}
-		close(pending)
-	}()
-
-	// save results and print feedback
-	wrote := 0
-	for r := range complete {
-		saveDomainResult(outputFile, r, *available)
-		wrote++
-		printFeedback(startTime, wrote, len(domains))
-		if wrote == len(domains) {
-			close(complete)
-		}
+	if err := outputFile.Sync(); err != nil {
+		showErrorAndExit(err, 6)
+	}
+	fmt.Printf("\nDone. %d domains could not be checked and were saved as UNKNOWN or ERROR.\n", stats.Unknown)
+	if stats.Disputed > 0 {
+		fmt.Printf("DNS servers disagreed on %d domains, saved as DISPUTED.\n", stats.Disputed)
+	}
+	if ctx.Err() != nil && stats.Total < 0 {
+		fmt.Print("Counting the domains left unchecked (interrupt again to quit now)...\n")
+		fmt.Printf("Interrupted. %d domains were left unchecked, run again with -resume to check them.\n",
+			domains.Count())
+	} else if ctx.Err() != nil {
+		fmt.Printf("Interrupted. %d of %d domains were left unchecked, run again with -resume to check them.\n",
+			stats.Total-stats.Checked, stats.Total)
 	}
-	fmt.Println("\nDone.")
+	printServerSummary(checker.Servers)
 }
@@ -0,0 +1,89 @@
+// Package journal implements an append-only record of checked domains, used to resume interrupted runs
+package journal
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Journal records each domain once its check is complete, one domain per line
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]bool
+}
+
+// Create a new empty journal at path, truncating any previous one
+func Create(path string) (*Journal, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file, done: map[string]bool{}}, nil
+}
+
+// Open an existing journal at path (or create it) and load the domains it already records. A last line cut short by
+// a crash is ignored, so that domain is checked again.
+func Open(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{file: file, done: map[string]bool{}}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		offset += int64(len(line))
+		if domain := strings.TrimSpace(line); domain != "" {
+			j.done[domain] = true
+		}
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, os.SEEK_SET); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Done returns true if domain was already recorded
+func (j *Journal) Done(domain string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done[domain]
+}
+
+// Len returns how many domains are recorded
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.done)
+}
+
+// Record appends domain to the journal
+func (j *Journal) Record(domain string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.done[domain] {
+		return nil
+	}
+	if _, err := j.file.WriteString(domain + "\n"); err != nil {
+		return err
+	}
+	j.done[domain] = true
+	return nil
+}
+
+// Close the journal file
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
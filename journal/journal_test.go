@@ -0,0 +1,58 @@
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func setupTestJournal(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "domainerator.journal.test.")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "TempDir", err, dir)
+	}
+	path := filepath.Join(dir, "journal")
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "WriteFile", err, path)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestOpenAndRecord(t *testing.T) {
+	path, cleanup := setupTestJournal(t, "golang.com\npylang.com\ngocod")
+	defer cleanup()
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "Open", err, path)
+	}
+	if !j.Done("golang.com") || !j.Done("pylang.com") || j.Done("gocod") || j.Len() != 2 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Open", "golang.com and pylang.com", j.done)
+	}
+	if err := j.Record("gocoder.com"); err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "Record", err, path)
+	}
+	j.Record("gocoder.com")
+	j.Close()
+
+	content, _ := ioutil.ReadFile(path)
+	expected := "golang.com\npylang.com\ngocoder.com\n"
+	if string(content) != expected {
+		t.Errorf(tests.ErrFmtExpectedGot, "Record", expected, string(content))
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path, cleanup := setupTestJournal(t, "golang.com\n")
+	defer cleanup()
+	j, err := Create(path)
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "Create", err, path)
+	}
+	defer j.Close()
+	if j.Done("golang.com") || j.Len() != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Create", "empty journal", j.done)
+	}
+}
@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hgfischer/domainerator/domain/query"
+)
+
+// Checker checks domains as Options say: asking Servers (or the authoritative servers of each public suffix, with
+// Iterative), confirming available domains by RDAP and WHOIS, caching results in Cache, and running up to
+// Concurrency checks at once.
+type Checker struct {
+	Options   Options
+	Servers   *query.ServerPool  // DNS servers in use and their health, empty with Iterative
+	Adaptive  *query.AIMDChecker // how many checks run at once
+	Hijackers map[string]string  // DNS servers dropped by HijackTest, and why
+	Wildcards []string           // public suffixes with wildcards, checked by SOA owner
+
+	checker query.Checker
+	pool    *query.UDPPool
+}
+
+// NewChecker returns a Checker for domains under psl. DNS servers and public suffixes are probed first, as Options
+// say, so it may take a while.
+func NewChecker(ctx context.Context, psl []string, options Options) (*Checker, error) {
+	if options.Log == nil {
+		options.Log = ioutil.Discard
+	}
+	options.Timeouts = withDefaults(options.Timeouts)
+	c := &Checker{Options: options}
+	retry := query.DefaultRetryPolicy
+	retry.MaxAttempts = options.Retries
+	retry.BaseDelay = options.Backoff
+
+	var checker query.Checker
+	var err error
+	if options.Iterative {
+		c.Servers = query.NewServerPool(nil)
+		checker, err = c.iterative(ctx, psl, retry)
+	} else {
+		checker, err = c.recursive(ctx, psl, retry)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.Adaptive = query.NewAIMDChecker(c.confirmation(checker, retry), options.Concurrency)
+	c.checker = c.Adaptive
+	if options.Cache != nil {
+		cached := query.NewCachedChecker(c.Adaptive, options.Cache)
+		cached.Refresh = options.Refresh
+		cached.Checks = options.checks()
+		c.checker = cached
+	}
+	return c, nil
+}
+
+// withDefaults returns timeouts with the ones left at zero set to query.DefaultTimeouts
+func withDefaults(timeouts query.Timeouts) query.Timeouts {
+	if timeouts.Dial == 0 {
+		timeouts.Dial = query.DefaultTimeouts.Dial
+	}
+	if timeouts.Write == 0 {
+		timeouts.Write = query.DefaultTimeouts.Write
+	}
+	if timeouts.Read == 0 {
+		timeouts.Read = query.DefaultTimeouts.Read
+	}
+	return timeouts
+}
+
+// Check implements query.Checker
+func (c *Checker) Check(ctx context.Context, domain string) query.Result {
+	return c.checker.Check(ctx, domain)
+}
+
+// Close releases the sockets of c. Options.Cache is left for the caller to close.
+func (c *Checker) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+	return c.pool.Close()
+}
+
+// usePool makes c send UDP queries through pool, with the sockets and window of Options
+func (c *Checker) usePool(pool *query.UDPPool) *query.UDPPool {
+	pool.Sockets = c.Options.Sockets
+	pool.Window = c.Options.Window
+	c.pool = pool
+	return pool
+}
+
+// recursive sets up a checker asking Servers
+func (c *Checker) recursive(ctx context.Context, psl []string, retry query.RetryPolicy) (query.Checker, error) {
+	o := c.Options
+	if len(o.Servers) == 0 {
+		return nil, errors.New("You need to specify a DNS server")
+	}
+	var specs []string
+	doh := false
+	for _, server := range o.Servers {
+		specs = append(specs, server.Spec)
+		doh = doh || server.Proto == "https"
+	}
+	if doh && o.DoHMethod != http.MethodGet && o.DoHMethod != http.MethodPost {
+		return nil, fmt.Errorf("Unknown DNS over HTTPS method: %q (should be \"GET\" or \"POST\")", o.DoHMethod)
+	}
+	transport := query.NewServerTransport(o.Servers, o.TLSConfig, o.DoHMethod, o.Timeouts)
+	c.usePool(transport.Pool)
+	nsChecker := query.NewNSChecker(specs, c.edns(transport))
+	nsChecker.Retry = retry
+	nsChecker.Rcodes = o.Rcodes
+	nsChecker.Servers.MaxFailureRate = o.MaxFailureRate
+	nsChecker.Servers.Cooldown = o.Cooldown
+	nsChecker.Limits = query.NewRateLimiter(o.ServerQPS, o.GlobalQPS)
+	c.Servers = nsChecker.Servers
+	if o.HijackTest {
+		if err := c.dropHijackers(ctx, nsChecker); err != nil {
+			return nil, err
+		}
+	}
+	if o.Wildcards {
+		c.findWildcards(ctx, nsChecker, psl)
+	}
+	nsChecker.Probe = o.Probe
+	if o.DNSSEC {
+		anchors := o.TrustAnchors
+		if anchors == nil {
+			var err error
+			if anchors, err = query.ParseTrustAnchors(query.RootTrustAnchors); err != nil {
+				return nil, err
+			}
+		}
+		nsChecker.DNSSEC = query.NewDNSSECValidator(anchors)
+	}
+	if o.Voters > 0 {
+		return c.quorum(nsChecker)
+	}
+	return nsChecker, nil
+}
+
+// edns adds the EDNS0 options to the queries sent through transport
+func (c *Checker) edns(transport query.Transport) query.Transport {
+	edns := query.NewEDNSTransport(transport)
+	edns.UDPSize = c.Options.EDNSSize
+	edns.Cookies = c.Options.Cookies
+	edns.Subnet = c.Options.Subnet
+	return edns
+}
+
+// dropHijackers probes DNS servers with random names and drops the ones making up answers for them
+func (c *Checker) dropHijackers(ctx context.Context, nsChecker *query.NSChecker) error {
+	fmt.Fprint(c.Options.Log, "Looking for NXDOMAIN hijacking DNS servers.. ")
+	c.Hijackers = nsChecker.FindHijackers(ctx, query.DefaultProbeTLDs, c.Options.Probes)
+	fmt.Fprintln(c.Options.Log, "done.")
+	for server, reason := range c.Hijackers {
+		fmt.Fprintf(c.Options.Log, "Dropping DNS server %s: %s\n", server, reason)
+		nsChecker.Servers.Remove(server)
+	}
+	if nsChecker.Servers.Len() == 0 {
+		return errors.New("Every DNS server is hijacking NXDOMAIN answers")
+	}
+	return nil
+}
+
+// findWildcards probes public suffixes with random names, so the ones with wildcard records are checked by SOA owner
+func (c *Checker) findWildcards(ctx context.Context, nsChecker *query.NSChecker, psl []string) {
+	fmt.Fprint(c.Options.Log, "Looking for public suffixes with wildcards.. ")
+	c.Wildcards = nsChecker.FindWildcards(ctx, psl, 3)
+	fmt.Fprintln(c.Options.Log, "done.")
+	if len(c.Wildcards) == 0 {
+		return
+	}
+	sort.Strings(c.Wildcards)
+	fmt.Fprintf(c.Options.Log, "Wildcard suffixes (checked by SOA owner): %s\n", strings.Join(c.Wildcards, ", "))
+	nsChecker.Wildcards = map[string]bool{}
+	for _, suffix := range c.Wildcards {
+		nsChecker.Wildcards[suffix] = true
+	}
+}
+
+func (c *Checker) quorum(nsChecker *query.NSChecker) (*query.QuorumChecker, error) {
+	voters := c.Options.Voters
+	if voters > nsChecker.Servers.Len() {
+		return nil, fmt.Errorf("Can't ask %d distinct DNS servers out of %d", voters, nsChecker.Servers.Len())
+	}
+	quorumChecker := query.NewQuorumChecker(nsChecker, voters)
+	if c.Options.Quorum != 0 {
+		quorumChecker.Quorum = c.Options.Quorum
+	}
+	if quorumChecker.Quorum <= voters/2 || quorumChecker.Quorum > voters {
+		return nil, fmt.Errorf("Invalid quorum: %d (should be more than half of %d voters)", c.Options.Quorum, voters)
+	}
+	return quorumChecker, nil
+}
+
+// iterative sets up a checker finding the authoritative servers of every public suffix and asking them directly
+func (c *Checker) iterative(ctx context.Context, psl []string, retry query.RetryPolicy) (query.Checker, error) {
+	o := c.Options
+	if o.Protocol != "udp" && o.Protocol != "tcp" {
+		return nil, fmt.Errorf("Protocol %q can't be used with -iterative", o.Protocol)
+	}
+	if o.DNSSEC || o.Voters > 0 || o.Probe {
+		return nil, errors.New("-dnssec, -voters and -probe can't be used with -iterative")
+	}
+	roots := o.Roots
+	if roots == nil {
+		var err error
+		if roots, err = query.ParseRootHints(query.RootHints, o.IPv6); err != nil {
+			return nil, err
+		}
+	}
+	var transport query.Transport
+	if o.Protocol == "udp" {
+		udp := query.NewUDPTransport(c.usePool(query.NewUDPPool()))
+		udp.Timeouts = o.Timeouts
+		transport = udp
+	} else {
+		tcp := query.NewDNSTransport(o.Protocol)
+		tcp.Timeouts = o.Timeouts
+		transport = tcp
+	}
+	iterChecker := query.NewIterativeChecker(roots, c.edns(transport))
+	iterChecker.IPv6 = o.IPv6
+	iterChecker.Retry = retry
+	iterChecker.Rcodes = o.Rcodes
+	iterChecker.Limits = query.NewRateLimiter(o.ServerQPS, o.GlobalQPS)
+
+	fmt.Fprint(o.Log, "Looking for the authoritative servers of public suffixes.. ")
+	for _, ps := range psl {
+		if _, err := iterChecker.Servers(ctx, ps); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintln(o.Log, "done.")
+	return iterChecker, nil
+}
+
+// confirmation chains checker with RDAP and/or WHOIS checkers to confirm available domains
+func (c *Checker) confirmation(checker query.Checker, retry query.RetryPolicy) query.Checker {
+	if c.Options.RDAP != nil {
+		rdapChecker := query.NewRDAPChecker(c.Options.RDAP)
+		rdapChecker.Retry = retry
+		checker = query.Chain{checker, rdapChecker}
+	}
+	if c.Options.WHOIS != nil {
+		whoisChecker := query.NewWHOISChecker(c.Options.WHOIS)
+		whoisChecker.Retry = retry
+		checker = query.Chain{checker, whoisChecker}
+	}
+	return checker
+}
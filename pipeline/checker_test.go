@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hgfischer/domainerator/domain/query"
+	"github.com/hgfischer/domainerator/tests"
+	"github.com/miekg/dns"
+)
+
+// takenHandler delegates names starting with "taken." and nothing else
+func takenHandler(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	q := req.Question[0]
+	if strings.HasPrefix(q.Name, "taken.") && q.Qtype == dns.TypeNS {
+		rr, _ := dns.NewRR(q.Name + " 3600 IN NS ns.example.net.")
+		m.Answer = append(m.Answer, rr)
+	} else if !strings.HasPrefix(q.Name, "taken.") {
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+}
+
+func startDNSServer(t *testing.T) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "ListenPacket", "No Error", err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(takenHandler),
+		NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+// memorySink keeps Results by domain, failing with err once it has limit of them
+type memorySink struct {
+	mu      sync.Mutex
+	results map[string]query.Result
+	limit   int
+	err     error
+}
+
+func (s *memorySink) Save(r query.Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit > 0 && len(s.results) >= s.limit {
+		return s.err
+	}
+	s.results[r.Domain] = r
+	return nil
+}
+
+func testOptions(t *testing.T, addr string) Options {
+	servers, err := query.ParseServers(addr, "udp")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "ParseServers", err, addr)
+	}
+	options := DefaultOptions()
+	options.Servers = servers
+	options.ServerQPS = 0
+	options.Concurrency = 4
+	return options
+}
+
+func TestCheckerRun(t *testing.T) {
+	addr, stop := startDNSServer(t)
+	defer stop()
+	c, err := NewChecker(context.Background(), []string{"test"}, testOptions(t, addr))
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "NewChecker", err, addr)
+	}
+	defer c.Close()
+	if len(c.Hijackers) != 0 || len(c.Wildcards) != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "NewChecker", "no hijackers or wildcards", c.Hijackers)
+	}
+
+	domains := []string{"taken.test", "free.test", "other.test"}
+	sink := &memorySink{results: map[string]query.Result{}}
+	calls := 0
+	stats, err := c.Run(context.Background(), List(domains), sink, func(Stats) { calls++ })
+	if err != nil || stats.Total != 3 || stats.Checked != 3 || stats.Unknown != 0 || calls != 3 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Run", "3 domains checked", stats)
+	}
+	for domain, status := range map[string]query.Status{
+		"taken.test": query.StatusRegistered, "free.test": query.StatusAvailable, "other.test": query.StatusAvailable,
+	} {
+		if r := sink.results[domain]; r.Status != status {
+			t.Errorf(tests.ErrFmtExpectedGot, "Run", domain+" "+status.String(), r.String(false))
+		}
+	}
+
+	failing := &memorySink{results: map[string]query.Result{}, limit: 1, err: errors.New("disk full")}
+	if _, err := c.Run(context.Background(), List(domains), failing, nil); err != failing.err {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Run", failing.err, err)
+	}
+}
+
+func TestNewCheckerErrors(t *testing.T) {
+	addr, stop := startDNSServer(t)
+	defer stop()
+	options := testOptions(t, addr)
+	options.Voters = 2
+	if _, err := NewChecker(context.Background(), []string{"test"}, options); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "NewChecker", "error for more voters than servers", "No Error")
+	}
+	options = testOptions(t, addr)
+	options.Servers = nil
+	if _, err := NewChecker(context.Background(), []string{"test"}, options); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "NewChecker", "error for no servers", "No Error")
+	}
+	options = testOptions(t, addr)
+	options.Iterative, options.Probe = true, true
+	if _, err := NewChecker(context.Background(), []string{"test"}, options); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "NewChecker", "error for -probe with -iterative", "No Error")
+	}
+}
+
+func TestOptionsChecks(t *testing.T) {
+	if checks := (Options{}).checks(); len(checks) != 0 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "checks", "none", checks)
+	}
+	options := Options{DNSSEC: true, Probe: true, Voters: 3, Iterative: true}
+	expected := []string{query.CheckDNSSEC, query.CheckProbe, query.CheckQuorum, query.CheckIterative}
+	if checks := options.checks(); !reflect.DeepEqual(checks, expected) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "checks", expected, checks)
+	}
+}
+
+func TestNewCheckerWithBareOptions(t *testing.T) {
+	addr, stop := startDNSServer(t)
+	defer stop()
+	servers, err := query.ParseServers(addr, "udp")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "ParseServers", err, addr)
+	}
+	c, err := NewChecker(context.Background(), []string{"test"}, Options{Servers: servers})
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "NewChecker", err, addr)
+	}
+	defer c.Close()
+	if r := c.Check(context.Background(), "taken.test"); r.Status != query.StatusRegistered {
+		t.Errorf(tests.ErrFmtExpectedGot, "Check", query.StatusRegistered.String(), r.String(false))
+	}
+}
@@ -0,0 +1,204 @@
+package pipeline
+
+import (
+	"errors"
+	"hash/crc32"
+
+	"github.com/hgfischer/domainerator/domain/name"
+	"github.com/hgfischer/domainerator/domain/ns"
+	"github.com/hgfischer/domainerator/wordlist"
+)
+
+// Errors of a Generator
+var (
+	ErrEmptyWordLists = errors.New("Empty wordlists")
+	ErrNoDomains      = errors.New("I could not generate a single valid domain")
+)
+
+// PublicSuffixes parses a CSV list of public suffixes, adding every TLD with all. Suffixes with UTF-8 characters are
+// left out unless utf8 is set.
+func PublicSuffixes(csv string, all, utf8 bool) ([]string, error) {
+	psl, err := name.ParsePublicSuffixCSV(csv, ns.PublicSuffixes, all)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8 {
+		psl = wordlist.FilterUTF8(psl)
+	}
+	return psl, nil
+}
+
+// Generator combines words with public suffixes into domains, according to the generation fields of Options
+type Generator struct {
+	Options        Options
+	PublicSuffixes []string
+}
+
+// NewGenerator returns a Generator of domains under psl
+func NewGenerator(psl []string, options Options) *Generator {
+	return &Generator{Options: options, PublicSuffixes: psl}
+}
+
+// Generate returns the domains made of prefixes and suffixes, without duplicates
+func (g *Generator) Generate(prefixes, suffixes []string) ([]string, error) {
+	stream, err := g.Stream(prefixes, suffixes, nil)
+	if err != nil {
+		return nil, err
+	}
+	var domains []string
+	stream.Each(func(domain string) bool {
+		domains = append(domains, domain)
+		return true
+	})
+	return domains, nil
+}
+
+// Stream returns the domains made of prefixes and suffixes, without duplicates and leaving out the ones skip (if set)
+// returns true for. Nothing is generated until the Stream is walked.
+func (g *Generator) Stream(prefixes, suffixes []string, skip func(domain string) bool) (*Stream, error) {
+	if len(prefixes) == 0 && len(suffixes) == 0 {
+		return nil, ErrEmptyWordLists
+	}
+	s := &Stream{generator: g, prefixes: prefixes, suffixes: suffixes, skip: skip}
+	found := false
+	s.candidates(func(string) bool {
+		found = true
+		return false
+	})
+	if !found {
+		return nil, ErrNoDomains
+	}
+	s.expected = g.estimate(prefixes, suffixes)
+	filterBits := maxInt(g.Options.DedupMemory/2, 8) * 8
+	s.partitions = minInt((s.expected*bitsPerDomain+filterBits-1)/filterBits, maxPartitions)
+	if s.partitions < 1 {
+		s.partitions = 1
+	}
+	return s, nil
+}
+
+// estimate how many domains prefixes and suffixes may be combined into, to size the Bloom filter finding duplicates
+func (g *Generator) estimate(prefixes, suffixes []string) int {
+	o := g.Options
+	phrases := 1
+	if o.Hyphenate {
+		phrases++
+	}
+	if o.Fuse {
+		phrases++
+	}
+	words := len(prefixes) * len(suffixes) * phrases
+	if o.Single {
+		words += len(prefixes) + len(suffixes)
+	}
+	return words * len(g.PublicSuffixes)
+}
+
+const (
+	bitsPerDomain = 10      // of the Bloom filter finding duplicates, for about 1% of false positives
+	maxPartitions = 1 << 16 // the exact set of duplicates is left unbounded past this many
+)
+
+// Stream of domains, generated lazily each time it is walked. Half of Options.DedupMemory holds a Bloom filter, and
+// the other half the domains it flags as duplicates, to be confirmed exactly. When they don't fit, domains are split
+// by hash into partitions, walked one after the other, each generated once to find its duplicates and once more to
+// yield it. So domains come in the order they are generated within each partition only.
+type Stream struct {
+	generator          *Generator
+	prefixes, suffixes []string
+	skip               func(domain string) bool
+	expected           int // domains, to size the Bloom filter
+	partitions         int // to start with, more are made when the duplicates of one don't fit
+}
+
+// Len implements Domains. How many domains s has is only known once walked, so it returns -1.
+func (s *Stream) Len() int {
+	return -1
+}
+
+// Count walks s to tell how many domains it has, which takes as long as generating them all
+func (s *Stream) Count() int {
+	n := 0
+	s.Each(func(string) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Each implements Domains
+func (s *Stream) Each(yield func(domain string) bool) {
+	memory := s.generator.Options.DedupMemory / 2
+	filter := wordlist.NewBloomFilter(memory, s.expected/s.partitions)
+	for p := 0; p < s.partitions; p++ {
+		if !s.partition(p, s.partitions, filter, memory, yield) {
+			return
+		}
+	}
+}
+
+// partition calls yield with each domain whose hash modulo m is r, splitting it in two when its duplicates take more
+// than memory bytes. It returns false if yield did.
+func (s *Stream) partition(r, m int, filter *wordlist.BloomFilter, memory int, yield func(string) bool) bool {
+	each := func(yield func(string) bool) {
+		s.candidates(func(domain string) bool {
+			return int(crc32.ChecksumIEEE([]byte(domain))%uint32(m)) != r || yield(domain)
+		})
+	}
+	if m >= maxPartitions {
+		memory = -1
+	}
+	filter.Reset()
+	duplicates, ok := wordlist.FindDuplicates(each, filter, memory)
+	if !ok {
+		duplicates = nil
+		return s.partition(r, 2*m, filter, memory, yield) && s.partition(r+m, 2*m, filter, memory, yield)
+	}
+	complete := true
+	each(func(domain string) bool {
+		if yielded, found := duplicates[domain]; found {
+			if yielded {
+				return true
+			}
+			duplicates[domain] = true
+		}
+		if s.skip != nil && s.skip(domain) {
+			return true
+		}
+		complete = yield(domain)
+		return complete
+	})
+	return complete
+}
+
+// candidates calls yield with every domain combined and filtered as Options say, duplicates included
+func (s *Stream) candidates(yield func(domain string) bool) {
+	o := s.generator.Options
+	name.EachCombination(s.prefixes, s.suffixes, s.generator.PublicSuffixes, o.Single, o.Hyphenate, o.Itself, o.Hacks,
+		o.Fuse, o.MinLength, func(domain string) bool {
+			if !o.UTF8 && wordlist.HasUTF8(domain) {
+				return true
+			}
+			if !name.FitsMaxLength(domain, o.MaxLength) {
+				return true
+			}
+			if o.Strict && name.Prohibited(domain, ns.PublicSuffixes) {
+				return true
+			}
+			return yield(domain)
+		})
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hgfischer/domainerator/domain/name"
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func TestPublicSuffixes(t *testing.T) {
+	psl, err := PublicSuffixes("com,net", false, false)
+	if err != nil || !reflect.DeepEqual(psl, []string{"com", "net"}) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "PublicSuffixes", []string{"com", "net"}, psl)
+	}
+	if _, err := PublicSuffixes("com,not-a-suffix", false, false); err == nil {
+		t.Errorf(tests.ErrFmtExpectedGot, "PublicSuffixes", "error", "No Error")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	options := DefaultOptions()
+	options.Single = false
+	g := NewGenerator([]string{"com"}, options)
+	domains, err := g.Generate([]string{"go", "go"}, []string{"lang"})
+	if err != nil || !reflect.DeepEqual(domains, []string{"golang.com"}) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Generate", []string{"golang.com"}, domains)
+	}
+
+	if _, err := g.Generate(nil, nil); err != ErrEmptyWordLists {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Generate", ErrEmptyWordLists, err)
+	}
+	g.Options.MaxLength = 5
+	if _, err := g.Generate([]string{"go"}, []string{"lang"}); err != ErrNoDomains {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Generate", ErrNoDomains, err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	options := DefaultOptions()
+	options.Hacks = false
+	options.Strict = false
+	g := NewGenerator([]string{"com", "net"}, options)
+	done := map[string]bool{"go.net": true}
+	stream, err := g.Stream([]string{"go", "py"}, []string{"go", "py"}, func(domain string) bool {
+		return done[domain]
+	})
+	if err != nil {
+		t.Fatalf(tests.ErrFmtExpectedGot, "Stream", "No Error", err)
+	}
+	expected := []string{"go.com", "py.com", "py.net", "gopy.com", "gopy.net", "pygo.com", "pygo.net"}
+	var domains []string
+	stream.Each(func(domain string) bool {
+		domains = append(domains, domain)
+		return true
+	})
+	if !reflect.DeepEqual(domains, expected) || stream.Len() != -1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Stream", expected, domains)
+	}
+
+	domains = nil
+	stream.Each(func(domain string) bool {
+		domains = append(domains, domain)
+		return len(domains) < 2
+	})
+	if !reflect.DeepEqual(domains, expected[:2]) {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Stream.Each", expected[:2], domains)
+	}
+
+	// domains checked meanwhile are no longer counted
+	done["go.com"] = true
+	if count := stream.Count(); count != len(expected)-1 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Stream.Count", len(expected)-1, count)
+	}
+}
+
+func TestStreamPartitions(t *testing.T) {
+	words := []string{"ab", "abc", "bc", "cab", "ca", "ba", "cc"}
+	options := DefaultOptions()
+	options.Strict = false
+	options.Hyphenate = true
+	options.Itself = true
+	options.MinLength = 1
+	psl := []string{"com", "net", "c", "bc"}
+	expected := map[string]bool{}
+	for _, domain := range name.Combine(words, words, psl, true, true, true, true, true, 1) {
+		expected[domain] = true
+	}
+	// with so little memory duplicates never fit, and domains are split into many partitions
+	for _, memory := range []int{64 << 20, 256} {
+		options.DedupMemory = memory
+		stream, err := NewGenerator(psl, options).Stream(words, words, nil)
+		if err != nil {
+			t.Fatalf(tests.ErrFmtExpectedGot, "Stream", "No Error", err)
+		}
+		domains := map[string]bool{}
+		stream.Each(func(domain string) bool {
+			if domains[domain] {
+				t.Errorf(tests.ErrFmtExpectedGot, "Stream", "no duplicates", domain)
+			}
+			domains[domain] = true
+			return true
+		})
+		if !reflect.DeepEqual(domains, expected) {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Stream", len(expected), len(domains))
+		}
+	}
+}
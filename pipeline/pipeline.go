@@ -0,0 +1,121 @@
+// Package pipeline generates domain names from word lists and checks them, for programs embedding domainerator. The
+// domainerator command is a thin wrapper around it.
+package pipeline
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hgfischer/domainerator/domain/query"
+	"github.com/miekg/dns"
+)
+
+// Options drive a Generator and a Checker. DefaultOptions has the defaults of the domainerator command.
+type Options struct {
+	// Generation of domains
+	Single      bool // also check single words
+	Itself      bool // combine words with themselves
+	Hyphenate   bool // include hyphenated combinations
+	Hacks       bool // domain hacks, like exam.pl for example
+	Fuse        bool // fuse words if letters match (ab + bc = abc)
+	UTF8        bool // keep words and public suffixes with UTF-8 characters
+	Strict      bool // filter possibly prohibited domains (domain == tld, etc)
+	MinLength   int  // without public suffix
+	MaxLength   int  // with public suffix
+	DedupMemory int  // bytes of the Bloom filter finding duplicate domains
+
+	// DNS servers and how to talk to them
+	Servers   []*query.Server
+	Protocol  string // of Iterative queries: udp or tcp
+	TLSConfig *tls.Config
+	DoHMethod string
+	Timeouts  query.Timeouts
+	Sockets   int    // UDP sockets shared by all queries
+	Window    int    // UDP queries in flight to each server
+	EDNSSize  uint16 // 0 for no EDNS0
+	Cookies   bool
+	Subnet    *net.IPNet
+
+	// Checks
+	Retries        int // attempts for each domain
+	Backoff        time.Duration
+	Rcodes         query.RcodePolicy
+	MaxFailureRate float64
+	Cooldown       time.Duration
+	ServerQPS      float64 // 0 for unlimited
+	GlobalQPS      float64 // 0 for unlimited
+	HijackTest     bool    // drop servers making up answers for random names
+	Probes         int     // random names per TLD for HijackTest
+	Wildcards      bool    // check public suffixes with wildcards by SOA owner
+	Probe          bool    // composite probe for undelegated and parked domains
+	DNSSEC         bool
+	TrustAnchors   []*dns.DS           // for DNSSEC, built-in ones when nil
+	Voters         int                 // distinct servers asked about each domain, 0 for one
+	Quorum         int                 // 0 for a majority of Voters
+	Iterative      bool                // ask authoritative servers instead of Servers
+	Roots          []string            // for Iterative, built-in ones when nil
+	IPv6           bool                // for Iterative
+	RDAP           query.RDAPBootstrap // confirm available domains by RDAP, if set
+	WHOIS          query.WHOISConfig   // confirm available domains by WHOIS, if set
+	Cache          *query.Cache
+	Refresh        bool // ignore cached results
+	Concurrency    int  // most checks at once, adapted at runtime
+
+	Log io.Writer // where setup steps are told about, if set
+}
+
+// DefaultOptions returns the defaults of the domainerator command, with no DNS servers
+func DefaultOptions() Options {
+	return Options{
+		Single:         true,
+		Hacks:          true,
+		Fuse:           true,
+		Strict:         true,
+		MinLength:      3,
+		MaxLength:      64,
+		DedupMemory:    64 << 20,
+		Protocol:       "udp",
+		DoHMethod:      "GET",
+		Timeouts:       query.DefaultTimeouts,
+		Sockets:        query.DefaultUDPSockets,
+		Window:         query.DefaultUDPWindow,
+		EDNSSize:       query.DefaultEDNSSize,
+		Retries:        4,
+		Backoff:        250 * time.Millisecond,
+		Rcodes:         query.DefaultRcodePolicy,
+		MaxFailureRate: query.DefaultMaxFailureRate,
+		Cooldown:       query.DefaultCooldown,
+		ServerQPS:      50,
+		HijackTest:     true,
+		Probes:         2,
+		Wildcards:      true,
+		Concurrency:    50,
+	}
+}
+
+// checks returns how domains are checked as o says, besides plain DNS queries, so cached Results checked in fewer
+// ways are not used
+func (o Options) checks() []string {
+	var checks []string
+	if o.DNSSEC {
+		checks = append(checks, query.CheckDNSSEC)
+	}
+	if o.RDAP != nil {
+		checks = append(checks, query.CheckRDAP)
+	}
+	if o.WHOIS != nil {
+		checks = append(checks, query.CheckWHOIS)
+	}
+	if o.Probe {
+		checks = append(checks, query.CheckProbe)
+	}
+	if o.Voters > 0 {
+		checks = append(checks, query.CheckQuorum)
+	}
+	if o.Iterative {
+		checks = append(checks, query.CheckIterative)
+	}
+	return checks
+}
@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hgfischer/domainerator/domain/query"
+)
+
+// Stats of a Run so far
+type Stats struct {
+	Started  time.Time
+	Total    int // domains to check, -1 until known
+	Checked  int // domains with a Result saved
+	Unknown  int // domains that could not be checked
+	Disputed int // domains DNS servers disagreed on
+}
+
+// Domains to Run, like a Stream or a List
+type Domains interface {
+	Each(yield func(domain string) bool) // calls yield with each domain in order, until it returns false
+	Len() int                            // how many domains there are, -1 if only known once walked
+}
+
+// List of Domains in a slice
+type List []string
+
+// Each implements Domains
+func (l List) Each(yield func(domain string) bool) {
+	for _, domain := range l {
+		if !yield(domain) {
+			return
+		}
+	}
+}
+
+// Len implements Domains
+func (l List) Len() int {
+	return len(l)
+}
+
+// Run checks domains and saves every Result to sink, calling progress (if set) after each one. It stops when ctx is
+// done or sink fails, after the checks in progress end, and returns the error of sink, if any. Domains left unchecked
+// are the ones not saved to sink.
+func (c *Checker) Run(ctx context.Context, domains Domains, sink Sink, progress func(Stats)) (Stats, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pending, complete := make(chan string), make(chan query.Result)
+
+	var workers sync.WaitGroup
+	for i := 0; i < c.Adaptive.Max; i++ {
+		workers.Add(1)
+		go func(id int) {
+			defer workers.Done()
+			query.CheckDomains(ctx, id, pending, complete, c)
+		}(i)
+	}
+	go func() {
+		workers.Wait()
+		close(complete)
+	}()
+
+	walked := make(chan int, 1) // how many domains there were, once all of them are pending
+	go func() {
+		defer close(pending)
+		total := 0
+		domains.Each(func(domain string) bool {
+			select {
+			case pending <- domain:
+				total++
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		if ctx.Err() == nil {
+			walked <- total
+		}
+	}()
+
+	stats := Stats{Started: time.Now(), Total: domains.Len()}
+	var err error
+	for r := range complete {
+		select {
+		case stats.Total = <-walked:
+		default:
+		}
+		if err != nil {
+			continue
+		}
+		if err = sink.Save(r); err != nil {
+			cancel()
+			continue
+		}
+		stats.Checked++
+		if r.Disputed() {
+			stats.Disputed++
+		} else if r.Unknown() {
+			stats.Unknown++
+		}
+		if progress != nil {
+			progress(stats)
+		}
+	}
+	select {
+	case stats.Total = <-walked:
+	default:
+	}
+	return stats, err
+}
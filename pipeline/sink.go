@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hgfischer/domainerator/domain/query"
+	"github.com/hgfischer/domainerator/journal"
+)
+
+// Sink takes the Results of a Run, one at a time
+type Sink interface {
+	Save(r query.Result) error
+}
+
+// WriterSink writes Results to W, one per line. With Available set, only available domains, and the ones that
+// could not be checked, are written, with no details.
+type WriterSink struct {
+	W         io.Writer
+	Available bool
+}
+
+// Save implements Sink
+func (s *WriterSink) Save(r query.Result) error {
+	if s.Available && r.Status != query.StatusAvailable && r.Status.Known() {
+		return nil
+	}
+	_, err := io.WriteString(s.W, r.String(s.Available))
+	return err
+}
+
+// JournalSink records each domain in Journal once Sink saved its Result, so an interrupted Run can be resumed. Domains
+// that could not be checked are left out, to be checked again on resume, see PruneOutput.
+type JournalSink struct {
+	Sink    Sink
+	Journal *journal.Journal
+}
+
+// Save implements Sink
+func (s *JournalSink) Save(r query.Result) error {
+	if err := s.Sink.Save(r); err != nil || r.Unknown() {
+		return err
+	}
+	return s.Journal.Record(r.Domain)
+}
+
+// PruneOutput rewrites the output file of an interrupted Run at path, keeping only the lines of domains done returns
+// true for. The lines of domains that could not be checked are dropped, as resuming the Run writes them again.
+func PruneOutput(path string, done func(domain string) bool) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	reader, writer := bufio.NewReader(file), bufio.NewWriter(tmp)
+	for {
+		line, err := reader.ReadString('\n')
+		if domain := strings.SplitN(strings.TrimSpace(line), "\t", 2)[0]; domain != "" && done(domain) {
+			if _, werr := writer.WriteString(strings.TrimSuffix(line, "\n") + "\n"); werr != nil {
+				tmp.Close()
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hgfischer/domainerator/domain/query"
+	"github.com/hgfischer/domainerator/journal"
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func TestWriterSink(t *testing.T) {
+	results := []query.Result{
+		{Domain: "free.com", Status: query.StatusAvailable},
+		{Domain: "taken.com", Status: query.StatusRegistered},
+		{Domain: "lost.com", Status: query.StatusUnknown},
+	}
+	var buf bytes.Buffer
+	sink := &WriterSink{W: &buf, Available: true}
+	for _, r := range results {
+		if err := sink.Save(r); err != nil {
+			t.Fatalf(tests.ErrFmtStringAtString, "Save", err, r.Domain)
+		}
+	}
+	expected := results[0].String(true) + results[2].String(true)
+	if buf.String() != expected {
+		t.Errorf(tests.ErrFmtExpectedGot, "Save", expected, buf.String())
+	}
+}
+
+func TestJournalSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "domainerator.pipeline.test.")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "TempDir", err, dir)
+	}
+	defer os.RemoveAll(dir)
+	j, err := journal.Create(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "Create", err, dir)
+	}
+	defer j.Close()
+
+	sink := &JournalSink{Sink: &WriterSink{W: ioutil.Discard}, Journal: j}
+	sink.Save(query.Result{Domain: "golang.com", Status: query.StatusRegistered})
+	sink.Save(query.Result{Domain: "pylang.com", Status: query.StatusError, Err: query.ErrUnsupported})
+	if !j.Done("golang.com") || j.Done("pylang.com") {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Save", "golang.com recorded", j.Len())
+	}
+}
+
+func TestResumeAfterUnknownResult(t *testing.T) {
+	dir, err := ioutil.TempDir("", "domainerator.pipeline.test.")
+	if err != nil {
+		t.Fatalf(tests.ErrFmtStringAtString, "TempDir", err, dir)
+	}
+	defer os.RemoveAll(dir)
+	output, path := filepath.Join(dir, "output"), filepath.Join(dir, "journal")
+	taken := query.Result{Domain: "golang.com", Status: query.StatusRegistered}
+	lost := query.Result{Domain: "pylang.com", Status: query.StatusUnknown, Err: query.ErrUnsupported}
+	found := query.Result{Domain: "pylang.com", Status: query.StatusAvailable}
+
+	// run and resume, saving each result to the output and journal opened as the command line does
+	for _, results := range [][]query.Result{{taken, lost}, {found}} {
+		j, err := journal.Open(path)
+		if err != nil {
+			t.Fatalf(tests.ErrFmtStringAtString, "Open", err, path)
+		}
+		if err := PruneOutput(output, j.Done); err != nil {
+			t.Fatalf(tests.ErrFmtStringAtString, "PruneOutput", err, output)
+		}
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			t.Fatalf(tests.ErrFmtStringAtString, "OpenFile", err, output)
+		}
+		sink := &JournalSink{Sink: &WriterSink{W: file}, Journal: j}
+		for _, r := range results {
+			if err := sink.Save(r); err != nil {
+				t.Fatalf(tests.ErrFmtStringAtString, "Save", err, r.Domain)
+			}
+		}
+		file.Close()
+		j.Close()
+	}
+
+	content, err := ioutil.ReadFile(output)
+	if expected := taken.String(false) + found.String(false); err != nil || string(content) != expected {
+		t.Errorf(tests.ErrFmtExpectedGot, "PruneOutput", expected, string(content))
+	}
+}
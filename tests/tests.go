@@ -3,5 +3,6 @@ package tests
 // Predefined errors
 const (
 	ErrFmtExpectedGot    = "%s() FAILED! Expected %q, got %q"
+	ErrFmtExpectedGotV   = "%s() FAILED! Expected %v, got %v"
 	ErrFmtStringAtString = "%s() FAILED! %q at %q"
 )
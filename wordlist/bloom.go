@@ -0,0 +1,95 @@
+package wordlist
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// BloomFilter remembers words in a fixed amount of memory. It never forgets a word it was given, but may rarely
+// claim to know one it was not given.
+type BloomFilter struct {
+	bits   []uint64
+	hashes int
+}
+
+// NewBloomFilter returns a BloomFilter using size bytes, with the best number of hashes for expected words
+func NewBloomFilter(size, expected int) *BloomFilter {
+	words := size / 8
+	if words < 1 {
+		words = 1
+	}
+	hashes := 1
+	if expected > 0 {
+		hashes = int(math.Ceil(float64(words*64) / float64(expected) * math.Ln2))
+	}
+	if hashes < 1 {
+		hashes = 1
+	} else if hashes > 16 {
+		hashes = 16
+	}
+	return &BloomFilter{bits: make([]uint64, words), hashes: hashes}
+}
+
+// Add word to f, returning true if f may have had it already
+func (f *BloomFilter) Add(word string) bool {
+	seen := true
+	f.each(word, func(bit uint64) {
+		mask := uint64(1) << (bit % 64)
+		if f.bits[bit/64]&mask == 0 {
+			seen = false
+			f.bits[bit/64] |= mask
+		}
+	})
+	return seen
+}
+
+// Has returns false if word was never added to f, and true if it may have been
+func (f *BloomFilter) Has(word string) bool {
+	has := true
+	f.each(word, func(bit uint64) {
+		has = has && f.bits[bit/64]&(uint64(1)<<(bit%64)) != 0
+	})
+	return has
+}
+
+// each calls fn with the bits of word, derived from two hashes of it
+func (f *BloomFilter) each(word string, fn func(bit uint64)) {
+	h1, h2 := fnv.New64a(), fnv.New64()
+	h1.Write([]byte(word))
+	h2.Write([]byte(word))
+	a, b := h1.Sum64(), h2.Sum64()|1
+	n := uint64(len(f.bits)) * 64
+	for i := 0; i < f.hashes; i++ {
+		fn((a + uint64(i)*b) % n)
+	}
+}
+
+// Reset makes f forget every word
+func (f *BloomFilter) Reset() {
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+}
+
+// wordOverhead is about how many bytes a map takes for each word, besides the word itself
+const wordOverhead = 48
+
+// FindDuplicates returns the words each calls yield with more than once, remembering the others in filter. A few
+// words seen only once may be returned too, so the result is a superset of the duplicates, to be confirmed by walking
+// the words again. It gives up, returning false, once the result takes more than size bytes (unless size < 0).
+func FindDuplicates(each func(yield func(word string) bool), filter *BloomFilter, size int) (map[string]bool, bool) {
+	duplicates := map[string]bool{}
+	used, ok := 0, true
+	each(func(word string) bool {
+		if !filter.Add(word) {
+			return true
+		}
+		if _, found := duplicates[word]; !found {
+			duplicates[word] = false
+			used += len(word) + wordOverhead
+			ok = size < 0 || used <= size
+		}
+		return ok
+	})
+	return duplicates, ok
+}
@@ -0,0 +1,53 @@
+package wordlist
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hgfischer/domainerator/tests"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := NewBloomFilter(1<<10, 100)
+	if f.Add("word0") || !f.Add("word0") {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Add", "false, then true", "something else")
+	}
+	for i := 1; i < 100; i++ {
+		f.Add(fmt.Sprintf("word%d", i))
+	}
+	falsePositives := 0
+	for i := 0; i < 100; i++ {
+		if !f.Has(fmt.Sprintf("word%d", i)) {
+			t.Errorf(tests.ErrFmtExpectedGotV, "Has", true, false)
+		}
+		if f.Has(fmt.Sprintf("other%d", i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 5 {
+		t.Errorf(tests.ErrFmtExpectedGotV, "Has false positives", "<= 5", falsePositives)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	words := []string{"a", "b", "a", "c", "b", "a"}
+	each := func(yield func(string) bool) {
+		for _, word := range words {
+			if !yield(word) {
+				return
+			}
+		}
+	}
+	for _, size := range []int{1 << 10, 8} {
+		// a tiny filter claims to have seen nearly everything, but never misses a duplicate
+		duplicates, ok := FindDuplicates(each, NewBloomFilter(size, len(words)), -1)
+		_, a := duplicates["a"]
+		_, b := duplicates["b"]
+		if !ok || !a || !b {
+			t.Errorf(tests.ErrFmtExpectedGotV, "FindDuplicates", "a and b", duplicates)
+		}
+	}
+	if _, ok := FindDuplicates(each, NewBloomFilter(1<<10, len(words)), wordOverhead+1); ok {
+		t.Errorf(tests.ErrFmtExpectedGotV, "FindDuplicates", "giving up past size", ok)
+	}
+}
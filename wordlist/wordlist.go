@@ -55,13 +55,18 @@ func RemoveDuplicates(words []string) []string {
 func FilterUTF8(words []string) []string {
 	var filtered []string
 	for _, word := range words {
-		if utf8.RuneCountInString(word) == len(word) {
+		if !HasUTF8(word) {
 			filtered = append(filtered, word)
 		}
 	}
 	return filtered
 }
 
+// HasUTF8 returns true if word has UTF8 encoded characters
+func HasUTF8(word string) bool {
+	return utf8.RuneCountInString(word) != len(word)
+}
+
 // FromCSV parse a CSV string into a cleaned slice of strings
 func FromCSV(csv string) []string {
 	csv = strings.TrimSpace(csv)